@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/roryq/fwd/fwd"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// adminServer serves the local control endpoints. It is only started when an
+// admin address is configured.
+type adminServer struct {
+	addr   string
+	routes *registry
+	recent *fwd.RecentEvents
+	inject bool
+}
+
+func newAdminServer(addr string, routes *registry, recent *fwd.RecentEvents, inject bool) *adminServer {
+	return &adminServer{
+		addr:   addr,
+		routes: routes,
+		recent: recent,
+		inject: inject,
+	}
+}
+
+func (a *adminServer) Serve(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/routes", a.handleRoutes)
+	mux.HandleFunc("/routes/", a.handleRoute)
+	mux.HandleFunc("/config", a.handleConfig)
+	mux.HandleFunc("/events/recent", a.handleRecentEvents)
+
+	infof("Admin server listening on %s", a.addr)
+	return serveHTTP(ctx, a.addr, mux)
+}
+
+// serveHTTP serves the handler on addr until the context is done.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return ctx.Err()
+}
+
+// handleConfig returns the routes currently running, with defaults applied
+// and secrets redacted, in the config file format.
+func (a *adminServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	routes := make(map[string]fwd.Route)
+	for source, route := range a.routes.routes() {
+		routes[fwd.RedactURL(source)] = route.Redacted()
+	}
+
+	writeJSON(w, configuration{Routes: routes})
+}
+
+// routeStatus describes a running route for /routes.
+type routeStatus struct {
+	ID      string                 `json:"id"`
+	Source  string                 `json:"source"`
+	Targets []string               `json:"targets"`
+	Status  fwd.SubscriptionStatus `json:"status"`
+}
+
+// handleRoutes lists the running routes and the state of their connections,
+// ordered by source.
+func (a *adminServer) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	fwders := a.routes.running()
+	sources := make([]string, 0, len(fwders))
+	for source := range fwders {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	routes := make([]routeStatus, len(sources))
+	for i, source := range sources {
+		f := fwders[source]
+		targets := f.Route().AllTargets()
+		for j, t := range targets {
+			targets[j] = fwd.RedactURL(t)
+		}
+		routes[i] = routeStatus{
+			ID:      f.ID(),
+			Source:  fwd.RedactURL(source),
+			Targets: targets,
+			Status:  f.Status(),
+		}
+	}
+	writeJSON(w, routes)
+}
+
+// handleRecentEvents returns the outcomes of the most recent events, oldest
+// first.
+func (a *adminServer) handleRecentEvents(w http.ResponseWriter, r *http.Request) {
+	events := []fwd.AuditEntry{}
+	if a.recent != nil {
+		events = a.recent.List()
+	}
+	writeJSON(w, events)
+}
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// handleRoute dispatches /routes/{id}/{action}. The escaped path is split, as
+// ids defaulting to the whole source are path escaped.
+func (a *adminServer) handleRoute(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/routes/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, ok := a.routes.byID(parts[0])
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown route %q", parts[0]), http.StatusNotFound)
+		return
+	}
+
+	switch parts[1] {
+	case "inject":
+		a.handleInject(w, r, f)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleInject pushes a synthetic event through the route's forward path. The
+// request body may be a smee-style envelope or a raw webhook body, in which
+// case it is wrapped using the request's content type and the "event" query
+// parameter as the GitHub event name.
+func (a *adminServer) handleInject(w http.ResponseWriter, r *http.Request, f *fwd.Fwder) {
+	if !a.inject {
+		http.Error(w, "event injection is disabled", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := injectedData(b, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ev := fwd.SSEvent{
+		Id:   fmt.Sprintf("inject-%d", time.Now().UnixNano()),
+		Data: data,
+	}
+	f.Inject(r.Context(), ev)
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, ev.Id)
+}
+
+func injectedData(b []byte, r *http.Request) ([]byte, error) {
+	var envelope map[string]json.RawMessage
+	if json.Unmarshal(b, &envelope) == nil {
+		if _, ok := envelope["body"]; ok {
+			return b, nil
+		}
+	}
+
+	return wrapBody(b, map[string]string{
+		"content-type":   r.Header.Get("Content-Type"),
+		"x-github-event": r.URL.Query().Get("event"),
+	})
+}
+
+// wrapBody builds a smee-style payload carrying the body and headers.
+func wrapBody(b []byte, headers map[string]string) ([]byte, error) {
+	body := json.RawMessage(b)
+	if !json.Valid(b) {
+		// Payload.Body is raw JSON, so non-JSON bodies travel as a string
+		s, _ := json.Marshal(string(b))
+		body = s
+	}
+
+	payload := map[string]interface{}{
+		"body":      body,
+		"timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	for k, v := range headers {
+		payload[k] = v
+	}
+	return json.Marshal(payload)
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/roryq/fwd/fwd"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type configuration struct {
+	// Groups hold route settings shared by the routes that name them.
+	Groups map[string]fwd.Route `json:",omitempty"`
+	Routes map[string]fwd.Route
+}
+
+// configPaths holds the -config flags, which may be repeated.
+type configPaths []string
+
+func (c *configPaths) String() string {
+	return strings.Join(*c, ", ")
+}
+
+func (c *configPaths) Set(path string) error {
+	*c = append(*c, path)
+	return nil
+}
+
+// configPathList returns the -config paths given, or the default path.
+func configPathList() []string {
+	if len(configPathArgs) == 0 {
+		return []string{defaultConfigPath}
+	}
+	return configPathArgs
+}
+
+func parseConfig() configuration {
+	config, err := loadConfigs(configPathList())
+	if err != nil {
+		errorf("error loading config: %s", err)
+		return configuration{}
+	}
+	return config
+}
+
+// loadConfigs loads and merges the configs at the paths, loading every config
+// file in those that are directories. Each source may only be in one file,
+// and groups apply only to the routes of the file that defines them.
+func loadConfigs(paths []string) (configuration, error) {
+	files, err := configFiles(paths)
+	if err != nil {
+		return configuration{}, err
+	}
+
+	merged := configuration{Routes: make(map[string]fwd.Route)}
+	from := make(map[string]string)
+	for _, file := range files {
+		config, err := loadConfig(file)
+		if err != nil {
+			return configuration{}, fmt.Errorf("%s: %w", file, err)
+		}
+		for source, route := range config.Routes {
+			if f, ok := from[source]; ok {
+				return configuration{}, fmt.Errorf("source %s is in both %s and %s", source, f, file)
+			}
+			merged.Routes[source] = route
+			from[source] = file
+		}
+	}
+	return merged, nil
+}
+
+// configFiles expands the config paths into the files, urls or stdin to load.
+// A directory is expanded into its .json, .yaml and .yml files, in name
+// order, and a missing default config is skipped.
+func configFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		path, err := expandHome(p)
+		if err != nil {
+			return nil, err
+		}
+		if path == "-" || isURL(path) {
+			files = append(files, path)
+			continue
+		}
+
+		fi, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) && p == defaultConfigPath {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".json" || isYAML(e.Name())) {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// loadConfig reads the config from a file, an http(s) url, or stdin if the
+// path is "-".
+func loadConfig(path string) (configuration, error) {
+	name, b, err := readConfig(path)
+	if err != nil {
+		return configuration{}, err
+	}
+	config, err := decodeConfig(name, b)
+	if err != nil {
+		return config, err
+	}
+	if isURL(path) {
+		err = checkRemoteExec(config.Routes)
+	}
+	return config, err
+}
+
+// configFetchTimeout bounds fetching the config from a url.
+const configFetchTimeout = 10 * time.Second
+
+// readConfig returns the config's contents, and a name for it whose extension
+// says whether it is YAML.
+func readConfig(path string) (string, []byte, error) {
+	switch {
+	case path == "-":
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", nil, err
+		}
+		// JSON configs are objects, so anything else must be YAML
+		if t := bytes.TrimSpace(b); len(t) > 0 && t[0] != '{' {
+			return "stdin.yaml", b, nil
+		}
+		return "stdin", b, nil
+
+	case isURL(path):
+		client := &http.Client{Timeout: configFetchTimeout}
+		resp, err := client.Get(path)
+		if err != nil {
+			return "", nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", nil, err
+		}
+		name := resp.Request.URL.Path
+		if strings.Contains(resp.Header.Get("Content-Type"), "yaml") {
+			name += ".yaml"
+		}
+		return name, b, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	return path, b, err
+}
+
+// watchable reports whether the config paths can be watched for changes,
+// which urls and stdin can't.
+func watchable(paths []string) bool {
+	for _, p := range paths {
+		if p == "-" || isURL(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// isURL reports whether the config path is an http(s) url.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// expandHome replaces a leading ~ in the path with the user's home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// decodeConfig parses the config, as YAML if the name has a YAML extension and
+// JSON otherwise, and resolves each route against its group.
+func decodeConfig(name string, b []byte) (configuration, error) {
+	var config configuration
+	if isYAML(name) {
+		var err error
+		if b, err = yamlToJSON(b); err != nil {
+			return config, err
+		}
+	}
+
+	if err := json.Unmarshal(b, &config); err != nil {
+		return config, err
+	}
+	set, err := routeKeys(b)
+	if err != nil {
+		return config, err
+	}
+
+	// groups are resolved before expanding the sources, which the set keys
+	// are keyed by as written
+	for source, route := range config.Routes {
+		if route.Group == "" {
+			continue
+		}
+
+		group, ok := config.Groups[route.Group]
+		if !ok {
+			return config, fmt.Errorf("route %s: unknown group %q", source, route.Group)
+		}
+		config.Routes[source] = inherit(route, group, set[source])
+	}
+
+	if config.Groups, err = expandRoutesEnv(config.Groups); err != nil {
+		return config, err
+	}
+	if config.Routes, err = expandRoutesEnv(config.Routes); err != nil {
+		return config, err
+	}
+
+	for source, route := range config.Routes {
+		config.Routes[source] = expandRouteHome(route)
+	}
+
+	return config, nil
+}
+
+// checkRemoteExec refuses exec targets and response urls in routes fetched
+// from a url, unless -allow-remote-exec is set, as they run commands on this
+// host.
+func checkRemoteExec(routes map[string]fwd.Route) error {
+	if allowRemoteExecArg {
+		return nil
+	}
+
+	sources := make([]string, 0, len(routes))
+	for source := range routes {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		route := routes[source]
+		for _, t := range append(route.AllTargets(), route.ResponseURL) {
+			if strings.HasPrefix(t, "exec:") {
+				return fmt.Errorf("route %s: exec target %q from a url needs -allow-remote-exec", fwd.RedactURL(source), t)
+			}
+		}
+	}
+	return nil
+}
+
+// expandRouteHome expands a leading ~ in the route's file paths, leaving any
+// it can't expand as they are.
+func expandRouteHome(route fwd.Route) fwd.Route {
+	for _, path := range []*string{&route.DeadLetterDir, &route.CAFile, &route.CertFile, &route.KeyFile} {
+		if p, err := expandHome(*path); err == nil {
+			*path = p
+		}
+	}
+	return route
+}
+
+// expandRoutesEnv substitutes environment variables in the sources and every
+// string value of the routes. Two sources expanding to the same one are an
+// error.
+func expandRoutesEnv(routes map[string]fwd.Route) (map[string]fwd.Route, error) {
+	if routes == nil {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(routes))
+	for k := range routes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	expanded := make(map[string]fwd.Route, len(routes))
+	from := make(map[string]string, len(routes))
+	for _, k := range keys {
+		route := routes[k]
+		expandValueEnv(reflect.ValueOf(&route).Elem())
+		e := expandEnv(k)
+		if other, ok := from[e]; ok {
+			return nil, fmt.Errorf("%s and %s both expand to %s", fwd.RedactURL(other), fwd.RedactURL(k), fwd.RedactURL(e))
+		}
+		expanded[e] = route
+		from[e] = k
+	}
+	return expanded, nil
+}
+
+func expandValueEnv(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(expandEnv(v.String()))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			expandValueEnv(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandValueEnv(v.Index(i))
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			e := reflect.New(iter.Value().Type()).Elem()
+			e.Set(iter.Value())
+			expandValueEnv(e)
+			v.SetMapIndex(iter.Key(), e)
+		}
+	}
+}
+
+// expandEnv replaces $VAR and ${VAR} with the variable's value, and $$ with a
+// literal $. Undefined variables expand to the empty string.
+func expandEnv(s string) string {
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			warnf("config references undefined environment variable %s", name)
+		}
+		return v
+	})
+}
+
+func isYAML(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// yamlToJSON converts YAML to JSON so that it is decoded by the same
+// UnmarshalJSON methods as a JSON config.
+func yamlToJSON(b []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// routeKeys returns the keys set in each route of the config, so that a route
+// can set a field back to its zero value, such as false, over its group's.
+// The short forms of a route set only its targets.
+func routeKeys(b []byte) (map[string]map[string]bool, error) {
+	var config struct {
+		Routes map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]map[string]bool)
+	for source, raw := range config.Routes {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			fields = map[string]json.RawMessage{"targets": nil}
+		}
+		keys[source] = make(map[string]bool)
+		for k := range fields {
+			keys[source][k] = true
+		}
+	}
+	return keys, nil
+}
+
+// targetFields are the fields inherited together, so that a route's targets
+// replace rather than add to its group's.
+var targetFields = map[string]bool{"target": true, "targets": true, "targets_by_type": true}
+
+// inherit fills each field of the route that is unset, and not in the set
+// keys, from the group's defaults. Maps are merged, with the route's entries
+// taking precedence. A route's id, group and enabled are its own.
+func inherit(route, group fwd.Route, set map[string]bool) fwd.Route {
+	r := reflect.ValueOf(&route).Elem()
+	g := reflect.ValueOf(group)
+	t := r.Type()
+
+	hasTargets := false
+	for i := 0; i < r.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if targetFields[name] && (set[name] || !r.Field(i).IsZero()) {
+			hasTargets = true
+		}
+	}
+
+	for i := 0; i < r.NumField(); i++ {
+		rf, gf := r.Field(i), g.Field(i)
+		name := jsonName(t.Field(i))
+		switch {
+		case name == "id" || name == "group" || name == "enabled":
+		case targetFields[name] && hasTargets:
+		case set[name] && rf.Kind() != reflect.Map:
+		case rf.Kind() == reflect.Map && !gf.IsNil():
+			merged := reflect.MakeMap(rf.Type())
+			for _, m := range []reflect.Value{gf, rf} {
+				iter := m.MapRange()
+				for iter.Next() {
+					merged.SetMapIndex(iter.Key(), iter.Value())
+				}
+			}
+			rf.Set(merged)
+		case rf.IsZero():
+			rf.Set(gf)
+		}
+	}
+	return route
+}
+
+// jsonName returns the name of the field in the config.
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"github.com/roryq/fwd/fwd"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroups(t *testing.T) {
+	config, err := decodeConfig("fwd.yaml", []byte(`
+groups:
+  github:
+    id: shared
+    enabled: false
+    target: http://localhost:3000/webhook
+    secret: s3cret
+    timeout: 10s
+    insecure_skip_verify: true
+    headers:
+      X-Env: prod
+      X-Team: web
+routes:
+  https://smee.io/inherits:
+    group: github
+  https://smee.io/overrides:
+    group: github
+    targets: [http://localhost:4000/]
+    timeout: 1s
+    insecure_skip_verify: false
+    headers:
+      X-Team: api
+  https://smee.io/short-form: http://localhost:5000/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inherits := config.Routes["https://smee.io/inherits"]
+	want := fwd.Route{
+		Target:             "http://localhost:3000/webhook",
+		Group:              "github",
+		Secret:             "s3cret",
+		Timeout:            fwd.Duration(10 * time.Second),
+		InsecureSkipVerify: true,
+		Headers:            map[string]string{"X-Env": "prod", "X-Team": "web"},
+	}
+	if !reflect.DeepEqual(inherits, want) {
+		t.Errorf("inheriting route = %+v, want %+v", inherits, want)
+	}
+
+	overrides := config.Routes["https://smee.io/overrides"]
+	want = fwd.Route{
+		Targets: []string{"http://localhost:4000/"},
+		Group:   "github",
+		Secret:  "s3cret",
+		Timeout: fwd.Duration(time.Second),
+		Headers: map[string]string{"X-Env": "prod", "X-Team": "api"},
+	}
+	if !reflect.DeepEqual(overrides, want) {
+		t.Errorf("overriding route = %+v, want %+v", overrides, want)
+	}
+
+	if got := config.Routes["https://smee.io/short-form"]; !reflect.DeepEqual(got, fwd.Route{Target: "http://localhost:5000/"}) {
+		t.Errorf("route without a group = %+v", got)
+	}
+}
+
+func TestGroupUnknown(t *testing.T) {
+	_, err := decodeConfig("fwd.json", []byte(`{"Routes": {"https://smee.io/a": {"target": "http://localhost/", "group": "nope"}}}`))
+	if err == nil || !strings.Contains(err.Error(), `unknown group "nope"`) {
+		t.Errorf("decodeConfig() error = %v, want an unknown group error", err)
+	}
+}
+
+func TestGroupShortFormReplacesTargets(t *testing.T) {
+	route := inherit(fwd.Route{Targets: []string{"http://b/"}}, fwd.Route{Target: "http://a/", TargetsByType: map[string][]string{"push": {"http://c/"}}}, map[string]bool{"targets": true})
+	if !reflect.DeepEqual(route, fwd.Route{Targets: []string{"http://b/"}}) {
+		t.Errorf("inherit() = %+v, want only the route's targets", route)
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"~", home},
+		{"~/.config/fwd/fwd.json", filepath.Join(home, ".config/fwd/fwd.json")},
+		{"/etc/fwd.json", "/etc/fwd.json"},
+		{"fwd.json", "fwd.json"},
+		{"~other/fwd.json", "~other/fwd.json"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := expandHome(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("expandHome(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeConfigYAML(t *testing.T) {
+	fromJSON, err := decodeConfig("fwd.json", []byte(`{
+		"Routes": {
+			"https://smee.io/a": "http://localhost:3000/",
+			"https://smee.io/b": {"targets": ["http://localhost:4000/", "http://localhost:5000/"], "max_attempts": 3}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"fwd.yaml", "fwd.yml", "FWD.YAML"} {
+		fromYAML, err := decodeConfig(name, []byte(`
+# comments are allowed in YAML
+routes:
+  https://smee.io/a: http://localhost:3000/
+  https://smee.io/b:
+    targets:
+      - http://localhost:4000/
+      - http://localhost:5000/
+    max_attempts: 3
+`))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !reflect.DeepEqual(fromYAML, fromJSON) {
+			t.Errorf("%s = %+v, want %+v", name, fromYAML, fromJSON)
+		}
+	}
+}
+
+func TestDecodeConfigInvalid(t *testing.T) {
+	if _, err := decodeConfig("fwd.yaml", []byte("routes: [")); err == nil {
+		t.Error("decodeConfig() of invalid YAML succeeded")
+	}
+	if _, err := decodeConfig("fwd.json", []byte("routes:\n  a: b\n")); err == nil {
+		t.Error("decodeConfig() of YAML named .json succeeded")
+	}
+}
+
+func TestConfigFilesInDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"b.yaml", "a.json", "c.yml", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := configFiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.yaml"), filepath.Join(dir, "c.yml")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("configFiles() = %v, want %v", files, want)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("FWD_TEST_TARGET", "http://localhost:3000")
+	os.Setenv("FWD_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("FWD_TEST_TARGET")
+	defer os.Unsetenv("FWD_TEST_SECRET")
+	os.Unsetenv("FWD_TEST_UNDEFINED")
+
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"${FWD_TEST_TARGET}/webhook", "http://localhost:3000/webhook"},
+		{"$FWD_TEST_SECRET", "s3cret"},
+		{"price: $$5", "price: $5"},
+		{"${FWD_TEST_UNDEFINED}", ""},
+		{"no variables", "no variables"},
+	}
+
+	for _, tt := range tests {
+		if got := expandEnv(tt.s); got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeConfigExpandsEnv(t *testing.T) {
+	os.Setenv("FWD_TEST_CHANNEL", "abc")
+	os.Setenv("FWD_TEST_TARGET", "http://localhost:3000")
+	os.Setenv("FWD_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("FWD_TEST_CHANNEL")
+	defer os.Unsetenv("FWD_TEST_TARGET")
+	defer os.Unsetenv("FWD_TEST_SECRET")
+
+	config, err := decodeConfig("fwd.json", []byte(`{"Routes": {"https://smee.io/${FWD_TEST_CHANNEL}": {
+		"targets": ["${FWD_TEST_TARGET}/a", "$FWD_TEST_TARGET/b"],
+		"secret": "${FWD_TEST_SECRET}",
+		"headers": {"Authorization": "Bearer $FWD_TEST_SECRET"},
+		"source_headers": {"Authorization": "Bearer ${FWD_TEST_SECRET}"}
+	}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]fwd.Route{"https://smee.io/abc": {
+		Targets:       []string{"http://localhost:3000/a", "http://localhost:3000/b"},
+		Secret:        "s3cret",
+		Headers:       map[string]string{"Authorization": "Bearer s3cret"},
+		SourceHeaders: map[string]string{"Authorization": "Bearer s3cret"},
+	}}
+	if !reflect.DeepEqual(config.Routes, want) {
+		t.Errorf("routes = %+v, want %+v", config.Routes, want)
+	}
+}
+
+func TestLoadConfigURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fwd.json":
+			w.Write([]byte(`{"Routes": {"https://smee.io/a": "http://localhost:3000/"}}`))
+		case "/config":
+			w.Header().Set("Content-Type", "application/yaml")
+			w.Write([]byte("routes:\n  https://smee.io/a: http://localhost:3000/\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	want := map[string]fwd.Route{"https://smee.io/a": {Target: "http://localhost:3000/"}}
+	for _, path := range []string{"/fwd.json", "/config"} {
+		config, err := loadConfigs([]string{srv.URL + path})
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if !reflect.DeepEqual(config.Routes, want) {
+			t.Errorf("%s: routes = %+v, want %+v", path, config.Routes, want)
+		}
+	}
+
+	if _, err := loadConfigs([]string{srv.URL + "/missing.json"}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("loadConfigs() of a missing url error = %v, want the status", err)
+	}
+}
+
+func TestLoadConfigURLRefusesExec(t *testing.T) {
+	configs := map[string]string{
+		"/target.json":   `{"Routes": {"https://smee.io/a": "exec:cat"}}`,
+		"/by-type.json":  `{"Routes": {"https://smee.io/a": {"targets_by_type": {"push": ["exec:cat"]}}}}`,
+		"/response.json": `{"Routes": {"https://smee.io/a": {"target": "http://localhost:3000/", "response_url": "exec:cat"}}}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(configs[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	for path := range configs {
+		_, err := loadConfigs([]string{srv.URL + path})
+		if err == nil || !strings.Contains(err.Error(), "-allow-remote-exec") {
+			t.Errorf("%s: loadConfigs() error = %v, want exec refused", path, err)
+		}
+	}
+
+	dir := writeConfigs(t, map[string]string{"fwd.json": configs["/target.json"]})
+	if _, err := loadConfigs([]string{filepath.Join(dir, "fwd.json")}); err != nil {
+		t.Errorf("loadConfigs() of a file error = %v, want exec allowed", err)
+	}
+
+	allowRemoteExecArg = true
+	defer func() { allowRemoteExecArg = false }()
+	for path := range configs {
+		if _, err := loadConfigs([]string{srv.URL + path}); err != nil {
+			t.Errorf("%s: loadConfigs() with -allow-remote-exec error = %v", path, err)
+		}
+	}
+}
+
+func TestRoutesPollerRefusesExec(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Routes": {"https://smee.io/a": "exec:cat"}}`))
+	}))
+	defer srv.Close()
+
+	routes := newTestRegistry(nil)
+	p := newRoutesPoller(srv.URL, time.Minute, routes)
+	if err := p.poll(context.Background()); err == nil || !strings.Contains(err.Error(), "-allow-remote-exec") {
+		t.Errorf("poll() error = %v, want exec refused", err)
+	}
+	if n := len(routes.routes()); n != 0 {
+		t.Errorf("%d routes running, want 0", n)
+	}
+}
+
+func TestLoadConfigStdin(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"json", `{"Routes": {"https://smee.io/a": "http://localhost:3000/"}}`},
+		{"yaml", "routes:\n  https://smee.io/a: http://localhost:3000/\n"},
+	}
+
+	want := map[string]fwd.Route{"https://smee.io/a": {Target: "http://localhost:3000/"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "fwd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			f.WriteString(tt.config)
+			f.Seek(0, 0)
+
+			stdin := os.Stdin
+			os.Stdin = f
+			defer func() { os.Stdin = stdin }()
+
+			config, err := loadConfigs([]string{"-"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Routes, want) {
+				t.Errorf("routes = %+v, want %+v", config.Routes, want)
+			}
+		})
+	}
+}
+
+func TestWatchable(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  bool
+	}{
+		{[]string{"fwd.json", "conf.d"}, true},
+		{[]string{"fwd.json", "-"}, false},
+		{[]string{"https://config.internal/fwd.json"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := watchable(tt.paths); got != tt.want {
+			t.Errorf("watchable(%q) = %t, want %t", tt.paths, got, tt.want)
+		}
+	}
+}
+
+// writeConfigs writes the files, by name, to a new directory.
+func writeConfigs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, config := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfigs(t *testing.T) {
+	dir := writeConfigs(t, map[string]string{
+		"a.json": `{"Groups": {"g": {"secret": "a"}}, "Routes": {"https://smee.io/a": {"target": "http://localhost:3000/", "group": "g"}}}`,
+		"b.yaml": "routes:\n  https://smee.io/b: http://localhost:4000/\n",
+	})
+	extra := writeConfigs(t, map[string]string{
+		"fwd.json": `{"Routes": {"https://smee.io/c": "http://localhost:5000/"}}`,
+	})
+
+	config, err := loadConfigs([]string{dir, filepath.Join(extra, "fwd.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]fwd.Route{
+		"https://smee.io/a": {Target: "http://localhost:3000/", Group: "g", Secret: "a"},
+		"https://smee.io/b": {Target: "http://localhost:4000/"},
+		"https://smee.io/c": {Target: "http://localhost:5000/"},
+	}
+	if !reflect.DeepEqual(config.Routes, want) {
+		t.Errorf("routes = %+v, want %+v", config.Routes, want)
+	}
+}
+
+func TestLoadConfigsConflicts(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{"duplicate source", map[string]string{
+			"a.json": `{"Routes": {"https://smee.io/a": "http://localhost:3000/"}}`,
+			"b.json": `{"Routes": {"https://smee.io/a": "http://localhost:4000/"}}`,
+		}, "source https://smee.io/a is in both"},
+		{"group from another file", map[string]string{
+			"a.json": `{"Groups": {"g": {"secret": "a"}}, "Routes": {}}`,
+			"b.json": `{"Routes": {"https://smee.io/b": {"target": "http://localhost:4000/", "group": "g"}}}`,
+		}, `unknown group "g"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfigs([]string{writeConfigs(t, tt.files)})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("loadConfigs() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestConfigPaths(t *testing.T) {
+	var paths configPaths
+	paths.Set("a.json")
+	paths.Set("conf.d")
+	if want := (configPaths{"a.json", "conf.d"}); !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+	if got := paths.String(); got != "a.json, conf.d" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestDecodeConfigGroupsWithEnvSources(t *testing.T) {
+	os.Setenv("FWD_TEST_CHANNEL", "abc")
+	defer os.Unsetenv("FWD_TEST_CHANNEL")
+
+	config, err := decodeConfig("fwd.json", []byte(`{
+		"Groups": {"g": {"target": "http://localhost:3000/", "forward_path": true}},
+		"Routes": {
+			"https://smee.io/${FWD_TEST_CHANNEL}": {"group": "g", "forward_path": false},
+			"https://smee.io/plain": {"group": "g", "forward_path": false}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for source, route := range config.Routes {
+		if route.ForwardPath {
+			t.Errorf("%s forward_path = true, want the route's false over the group's true", source)
+		}
+	}
+	if _, ok := config.Routes["https://smee.io/abc"]; !ok {
+		t.Errorf("routes = %v, want the source expanded", config.Routes)
+	}
+}
+
+func TestDecodeConfigEnvSourceCollision(t *testing.T) {
+	os.Setenv("FWD_TEST_CHANNEL", "abc")
+	defer os.Unsetenv("FWD_TEST_CHANNEL")
+
+	_, err := decodeConfig("fwd.json", []byte(`{"Routes": {
+		"https://smee.io/${FWD_TEST_CHANNEL}": "http://localhost:3000/",
+		"https://smee.io/abc": "http://localhost:4000/"
+	}}`))
+	want := "https://smee.io/${FWD_TEST_CHANNEL} and https://smee.io/abc both expand to https://smee.io/abc"
+	if err == nil || err.Error() != want {
+		t.Errorf("decodeConfig() error = %v, want %s", err, want)
+	}
+}
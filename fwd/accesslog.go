@@ -0,0 +1,91 @@
+package fwd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultAccessLogFormat is modelled on the common log format, with the
+// source in place of the client host and the target appended.
+const DefaultAccessLogFormat = `$source - - [$time] "$method $path" $status $bytes $duration $target`
+
+// AccessLog writes a line per forward attempt, in a format using the
+// variables $time, $source, $target, $method, $path, $status, $bytes and
+// $duration. Query values in $target and $path are redacted.
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// OpenAccessLog opens the access log at path, where "-" means stdout.
+func OpenAccessLog(path, format string) (*AccessLog, error) {
+	w := io.Writer(os.Stdout)
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	return &AccessLog{w: w, format: format}, nil
+}
+
+func (l *AccessLog) log(e accessEntry) {
+	if l == nil {
+		return
+	}
+
+	line := e.render(l.format)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.w, line)
+}
+
+// accessEntry describes a single forward. A zero status means no response
+// was received.
+type accessEntry struct {
+	time     time.Time
+	source   string
+	target   string
+	method   string
+	path     string
+	status   int
+	bytes    int
+	duration time.Duration
+}
+
+// render expands the $variables in format, e.g. $status or ${duration}.
+func (e accessEntry) render(format string) string {
+	return os.Expand(format, func(key string) string {
+		switch key {
+		case "time":
+			return e.time.Format("02/Jan/2006:15:04:05 -0700")
+		case "source":
+			return e.source
+		case "target":
+			return e.target
+		case "method":
+			return e.method
+		case "path":
+			return e.path
+		case "status":
+			if e.status == 0 {
+				return "-"
+			}
+			return strconv.Itoa(e.status)
+		case "bytes":
+			return strconv.Itoa(e.bytes)
+		case "duration":
+			return e.duration.String()
+		case "$":
+			return "$"
+		}
+		return ""
+	})
+}
@@ -0,0 +1,58 @@
+package fwd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessEntryRender(t *testing.T) {
+	e := accessEntry{
+		time:     time.Date(2021, 8, 1, 12, 30, 0, 0, time.UTC),
+		source:   "https://smee.io/abc",
+		target:   "http://localhost:3000/",
+		method:   "POST",
+		path:     "/hook?a=1",
+		status:   202,
+		bytes:    42,
+		duration: 1500 * time.Millisecond,
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		entry  accessEntry
+		want   string
+	}{
+		{"default", DefaultAccessLogFormat, e, `https://smee.io/abc - - [01/Aug/2021:12:30:00 +0000] "POST /hook?a=1" 202 42 1.5s http://localhost:3000/`},
+		{"braces", "${method}${path} $status", e, "POST/hook?a=1 202"},
+		{"no response", "$status", accessEntry{}, "-"},
+		{"literal dollar", "$$ $bytes", e, "$ 42"},
+		{"unknown variable", "[$nope]", e, "[]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.render(tt.format); got != tt.want {
+				t.Errorf("render(%q) = %q, want %q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogRedactsTarget(t *testing.T) {
+	target := newTestTarget(t)
+	var buf bytes.Buffer
+	l := &AccessLog{w: &buf, format: "$path $target $status"}
+
+	u := strings.Replace(target.URL, "http://", "http://user:secret@", 1) + "/hook?token=abc"
+	f := newTestFwder(t, Route{Target: u}, WithAccessLog(l))
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if line := buf.String(); strings.Contains(line, "secret") || strings.Contains(line, "abc") || !strings.HasPrefix(line, "/hook?token=xxxxx ") || !strings.HasSuffix(line, " 200\n") {
+		t.Errorf("access log line = %q, want the path and target redacted and a 200 status", line)
+	}
+}
@@ -0,0 +1,88 @@
+package fwd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The outcomes of forwarding an event, as recorded in the audit log.
+const (
+	OutcomeDelivered = "delivered"
+	OutcomeSkipped   = "skipped"
+	OutcomeFailed    = "failed"
+)
+
+// AuditLog records every event received, as a JSON object per line, with its
+// outcome for each target or why it was skipped. Unlike the logs it is written
+// whatever the log level. Keepalives, and events over an EventLimit, which are
+// left undelivered to be replayed, are not recorded.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// OpenAuditLog opens the audit log at path for appending, where "-" means
+// stdout.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	w := io.Writer(os.Stdout)
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	return &AuditLog{w: w}, nil
+}
+
+// AuditEntry is an event's outcome, as a line of the audit log. Skipped
+// events, and queued events abandoned at the drain timeout, have no target or
+// status, and a zero status for a failed event means no response was
+// received.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	Target    string    `json:"target,omitempty"`
+	EventID   string    `json:"event_id"`
+	EventType string    `json:"event_type,omitempty"`
+	Bytes     int       `json:"bytes"`
+	Status    int       `json:"status,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+func (l *AuditLog) log(e AuditEntry) {
+	if l == nil {
+		return
+	}
+
+	b, _ := json.Marshal(e)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(b, '\n'))
+}
+
+// audit records the event's outcome in the audit log and recent events.
+func (f *Fwder) audit(e AuditEntry) {
+	e.Time = time.Now()
+	e.Target = RedactURL(e.Target)
+	f.auditLog.log(e)
+	f.recent.add(e)
+}
+
+// auditSkip records that the event was skipped, and why.
+func (f *Fwder) auditSkip(ev SSEvent, eventType string, size int, reason string) {
+	f.audit(AuditEntry{
+		Source:    f.source,
+		EventID:   ev.Id,
+		EventType: eventType,
+		Bytes:     size,
+		Outcome:   OutcomeSkipped,
+		Reason:    reason,
+	})
+}
@@ -0,0 +1,86 @@
+package fwd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readAuditLog returns the entries in the audit log at path, without their
+// times.
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("audit log line %q: %s", scanner.Text(), err)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("audit log line %q has no time", scanner.Text())
+		}
+		e.Time = time.Time{}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	ok := newTestTarget(t)
+	failing := newTestTarget(t, 400)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestFwder(t, Route{Targets: []string{ok.URL + "/?token=abc", failing.URL}, Deny: []string{"issues"}}, WithAuditLog(l))
+	f.ForwardEvent(smeeEvent("1", map[string]string{"x-github-event": "push"}, `{"a":1}`))
+	f.ForwardEvent(smeeEvent("2", map[string]string{"x-github-event": "issues"}, `{}`))
+	f.ForwardEvent(SSEvent{Id: "3", Name: "ping"})
+
+	want := []AuditEntry{
+		{Source: testSource, Target: ok.URL + "/?token=xxxxx", EventID: "1", EventType: "push", Bytes: 7, Status: 200, Outcome: OutcomeDelivered},
+		{Source: testSource, Target: failing.URL, EventID: "1", EventType: "push", Bytes: 7, Status: 400, Outcome: OutcomeFailed},
+		{Source: testSource, EventID: "2", EventType: "issues", Bytes: 2, Outcome: OutcomeSkipped, Reason: "filtered type"},
+	}
+	got := readAuditLog(t, path)
+	// the targets are delivered to concurrently
+	if len(got) == 3 && got[0].Target == failing.URL {
+		got[0], got[1] = got[1], got[0]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log = %+v, want %+v", got, want)
+	}
+}
+
+func TestAuditLogAppends(t *testing.T) {
+	target := newTestTarget(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	for _, id := range []string{"1", "2"} {
+		l, err := OpenAuditLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := newTestFwder(t, Route{Target: target.URL}, WithAuditLog(l))
+		if _, err := f.ForwardEvent(smeeEvent(id, nil, `{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if entries := readAuditLog(t, path); len(entries) != 2 || entries[0].EventID != "1" || entries[1].EventID != "2" {
+		t.Errorf("audit log = %+v, want an entry for each event", entries)
+	}
+}
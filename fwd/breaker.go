@@ -0,0 +1,111 @@
+package fwd
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultBreakerCooldown = 30 * time.Second
+
+// breakerState is the state of a circuit breaker, with values matching the
+// fwd_circuit_state metric.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// errCircuitOpen is the error for forwards skipped by an open circuit.
+var errCircuitOpen = errors.New("circuit open")
+
+// circuitBreaker stops forwarding to a target after a run of consecutive
+// failures. Once the cooldown has passed a single probe is let through,
+// closing the circuit if it succeeds and opening it again if not.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int
+	opened    time.Time
+	probing   bool
+
+	// changed is called with the new state on every transition
+	changed func(breakerState)
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, changed func(breakerState)) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		changed:   changed,
+	}
+}
+
+// allow reports whether a forward may be attempted.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.opened) < b.cooldown {
+			return false
+		}
+		b.set(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// success records a successful forward, closing the circuit.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	b.set(breakerClosed)
+}
+
+// failure records a failed forward, opening the circuit if the probe failed
+// or the failures reached the threshold.
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.probing = false
+		b.opened = time.Now()
+		b.set(breakerOpen)
+	}
+}
+
+func (b *circuitBreaker) set(state breakerState) {
+	if state == b.state {
+		return
+	}
+	b.state = state
+	if b.changed != nil {
+		b.changed(state)
+	}
+}
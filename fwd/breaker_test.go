@@ -0,0 +1,62 @@
+package fwd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var states []string
+	b := newCircuitBreaker(2, 50*time.Millisecond, func(s breakerState) {
+		states = append(states, s.String())
+	})
+
+	b.failure()
+	if !b.allow() {
+		t.Fatal("circuit opened before the threshold")
+	}
+	b.failure()
+	if b.allow() {
+		t.Fatal("circuit closed after reaching the threshold")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("probe not allowed after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("second probe allowed while the first is in flight")
+	}
+
+	// a failed probe opens the circuit again at once
+	b.failure()
+	if b.allow() {
+		t.Fatal("circuit closed after a failed probe")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	b.allow()
+	b.success()
+	if !b.allow() || !b.allow() {
+		t.Error("circuit not closed after a successful probe")
+	}
+
+	want := []string{"open", "half-open", "open", "half-open", "closed"}
+	if !equalStrings(states, want) {
+		t.Errorf("transitions = %v, want %v", states, want)
+	}
+}
+
+func TestCircuitBreakerSkipsForwards(t *testing.T) {
+	target := newTestTarget(t, 500)
+	f := newTestFwder(t, Route{Target: target.URL, BreakerThreshold: 2, BreakerCooldown: Duration(time.Hour)})
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if _, err := f.ForwardEvent(smeeEvent(id, nil, `{}`)); err == nil {
+			t.Fatalf("event %s was delivered to a failing target", id)
+		}
+	}
+	if n := len(target.received()); n != 2 {
+		t.Errorf("target received %d events, want 2 before the circuit opened", n)
+	}
+}
@@ -0,0 +1,65 @@
+package fwd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// deadLetter is an event that could not be forwarded to a target, with
+// enough detail to inspect or replay it by hand. Body holds a JSON body as
+// is, while any other body is kept base64 encoded in RawBody. An event
+// abandoned while still queued has its payload as received for a body.
+type deadLetter struct {
+	Source   string
+	Target   string
+	EventID  string
+	Time     time.Time
+	Method   string
+	Header   http.Header
+	Body     json.RawMessage `json:",omitempty"`
+	RawBody  []byte          `json:",omitempty"`
+	Attempts int
+	Status   int    `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// unsafeFileChars matches anything not safe to use in a file name.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// deadLetter writes the failed event to the route's dead-letter directory.
+func (f *Fwder) deadLetter(log Logger, l deadLetter, body []byte) {
+	if json.Valid(body) {
+		l.Body = body
+	} else {
+		l.RawBody = body
+	}
+
+	dir := f.route.DeadLetterDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("error writing dead letter for event %s: %s", l.EventID, err)
+		return
+	}
+
+	b, _ := json.MarshalIndent(l, "", "  ")
+	name := fmt.Sprintf("%s-%s-%d.json", unsafeFileChars.ReplaceAllString(f.id, "_"),
+		unsafeFileChars.ReplaceAllString(l.EventID, "_"), l.Time.UnixNano())
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		log.Errorf("error writing dead letter for event %s: %s", l.EventID, err)
+		return
+	}
+	log.Warnf("Wrote undeliverable event %s for %s to %s", l.EventID, RedactURL(l.Target), path)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,94 @@
+package fwd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readDeadLetters returns the dead letters written to dir, by file name.
+func readDeadLetters(t *testing.T, dir string) map[string]deadLetter {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letters := make(map[string]deadLetter)
+	for _, e := range entries {
+		b, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var l deadLetter
+		if err := json.Unmarshal(b, &l); err != nil {
+			t.Fatalf("%s: %s", e.Name(), err)
+		}
+		letters[e.Name()] = l
+	}
+	return letters
+}
+
+func TestDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := newTestTarget(t, 503)
+	f := newTestFwder(t, Route{
+		Target:        target.URL,
+		MaxAttempts:   2,
+		RetryDelay:    Duration(time.Millisecond),
+		DeadLetterDir: filepath.Join(dir, "dead"),
+	})
+
+	f.ForwardEvent(smeeEvent("a/1", map[string]string{"x-github-event": "push"}, `{"ref":"main"}`))
+
+	letters := readDeadLetters(t, filepath.Join(dir, "dead"))
+	if len(letters) != 1 {
+		t.Fatalf("%d dead letters written, want 1", len(letters))
+	}
+	for name, l := range letters {
+		if !strings.HasPrefix(name, "test-a_1-") {
+			t.Errorf("dead letter name %q isn't made safe from the route and event id", name)
+		}
+		if l.Source != testSource || l.Target != target.URL || l.EventID != "a/1" {
+			t.Errorf("dead letter is for %s %s %s, want the event", l.Source, l.Target, l.EventID)
+		}
+		if l.Attempts != 2 || l.Status != 503 {
+			t.Errorf("dead letter has %d attempts, status %d, want 2 and 503", l.Attempts, l.Status)
+		}
+		var body bytes.Buffer
+		json.Compact(&body, l.Body)
+		if body.String() != `{"ref":"main"}` || l.Header.Get("X-Github-Event") != "push" {
+			t.Errorf("dead letter has body %s and headers %v, want the forwarded request", body.String(), l.Header)
+		}
+	}
+}
+
+func TestDeadLetterNotWrittenOnSuccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, DeadLetterDir: dir})
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if letters := readDeadLetters(t, dir); len(letters) != 0 {
+		t.Errorf("%d dead letters written for a delivered event", len(letters))
+	}
+}
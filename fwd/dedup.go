@@ -0,0 +1,57 @@
+package fwd
+
+import (
+	"container/list"
+	"sync"
+)
+
+const defaultDedupSize = 1000
+
+// idCache remembers the most recently delivered event ids, so that events
+// re-sent by the source, such as on reconnecting with Last-Event-ID, are only
+// forwarded once.
+type idCache struct {
+	mu    sync.Mutex
+	size  int
+	ids   map[string]*list.Element
+	order *list.List
+}
+
+func newIDCache(size int) *idCache {
+	return &idCache{
+		size:  size,
+		ids:   make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+// contains reports whether the id has been added, marking it as recently used
+// if so.
+func (c *idCache) contains(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.ids[id]
+	if ok {
+		c.order.MoveToFront(e)
+	}
+	return ok
+}
+
+// add records the id, evicting the least recently used id once full.
+func (c *idCache) add(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.ids[id]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.ids[id] = c.order.PushFront(id)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.ids, oldest.Value.(string))
+	}
+}
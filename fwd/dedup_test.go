@@ -0,0 +1,53 @@
+package fwd
+
+import "testing"
+
+func TestIDCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newIDCache(2)
+	c.add("1")
+	c.add("2")
+	c.contains("1")
+	c.add("3")
+
+	for id, want := range map[string]bool{"1": true, "2": false, "3": true} {
+		if got := c.contains(id); got != want {
+			t.Errorf("contains(%s) = %t, want %t", id, got, want)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL})
+
+	var reasons []string
+	for _, id := range []string{"1", "2", "1"} {
+		result, err := f.ForwardEvent(smeeEvent(id, nil, `{}`))
+		if err != nil {
+			t.Fatalf("event %s: %s", id, err)
+		}
+		reasons = append(reasons, result.Reason)
+	}
+
+	if want := []string{"", "", "duplicate"}; !equalStrings(reasons, want) {
+		t.Errorf("reasons = %q, want %q", reasons, want)
+	}
+	if n := len(target.received()); n != 2 {
+		t.Errorf("target received %d events, want 2", n)
+	}
+}
+
+func TestDedupRetriesFailedEvents(t *testing.T) {
+	target := newTestTarget(t, 500, 200)
+	f := newTestFwder(t, Route{Target: target.URL})
+
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err == nil {
+		t.Fatal("first forward succeeded, want it to fail")
+	}
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+		t.Fatalf("resent event wasn't forwarded again: %s", err)
+	}
+	if n := len(target.received()); n != 2 {
+		t.Errorf("target received %d events, want 2", n)
+	}
+}
@@ -0,0 +1,28 @@
+// Package fwd subscribes to server-sent event sources such as smee.io and
+// forwards the webhooks they relay to local targets. It is the core of the
+// fwd command, for embedding in other programs.
+//
+// A Fwder is a suture service, so it is run under a supervisor:
+//
+//	route := fwd.Route{Target: "http://localhost:3000/webhook", Include: []string{"push"}}
+//	f, err := fwd.NewFwder("https://smee.io/abc123", route,
+//		fwd.WithLogger(myLogger),
+//		fwd.WithFilter(func(ev fwd.SSEvent, p fwd.Payload) bool {
+//			return p.Header("x-github-event") != "ping"
+//		}),
+//	)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	supervisor := suture.NewSimple("webhooks")
+//	supervisor.Add(f)
+//	supervisor.Serve(ctx)
+//
+// Sources are read over SSE, or over a websocket for ws(s) urls and routes
+// with the websocket protocol.
+//
+// Forwards are made with the route's HTTP client unless WithHTTPClient is
+// given, whose transport can handle them in process instead, such as by
+// calling an http.Handler.
+package fwd
@@ -0,0 +1,147 @@
+package fwd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeDecodeBody(t *testing.T) {
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+	for _, encoding := range []string{"", "identity", "gzip", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			b, err := encodeBody(encoding, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodeBody(encoding, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(body) {
+				t.Errorf("round trip = %q, want %q", got, body)
+			}
+		})
+	}
+
+	if _, err := encodeBody("br", body); err == nil {
+		t.Error("encodeBody(br) succeeded, want an unsupported encoding error")
+	}
+}
+
+func TestConvertEncoding(t *testing.T) {
+	body := `{"action":"opened"}`
+	gzipped, _ := encodeBody("gzip", []byte(body))
+	s, _ := json.Marshal(base64.StdEncoding.EncodeToString(gzipped))
+
+	tests := []struct {
+		name         string
+		route        Route
+		ev           SSEvent
+		wantEncoding string
+	}{
+		{"gzip in, identity out", Route{Decompress: true}, smeeEvent("1", map[string]string{"content-encoding": "gzip", "content-type": "application/json"}, string(s)), ""},
+		{"identity in, gzip out", Route{Compress: "gzip"}, smeeEvent("1", map[string]string{"content-type": "application/json"}, body), "gzip"},
+		{"gzip passed through", Route{}, smeeEvent("1", map[string]string{"content-encoding": "gzip", "content-type": "application/json"}, string(s)), "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			tt.route.Target = target.URL
+			f := newTestFwder(t, tt.route)
+			if _, err := f.ForwardEvent(tt.ev); err != nil {
+				t.Fatal(err)
+			}
+
+			r := target.received()[0]
+			if got := r.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("content-encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			got, err := decodeBody(tt.wantEncoding, []byte(r.Body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("decoded body = %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestWireBody(t *testing.T) {
+	gzipped, _ := encodeBody("gzip", []byte(`{"a":1}`))
+	b64, _ := json.Marshal(base64.StdEncoding.EncodeToString(gzipped))
+
+	tests := []struct {
+		name    string
+		payload Payload
+		want    string
+	}{
+		{"json body", Payload{Body: json.RawMessage(`{"a":1}`)}, `{"a":1}`},
+		{"text body", Payload{ContentType: "text/plain", Body: json.RawMessage(`"a=1"`)}, "a=1"},
+		{"json string body", Payload{ContentType: "application/json", Body: json.RawMessage(`"a=1"`)}, `"a=1"`},
+		{"base64 compressed body", Payload{ContentEncoding: "gzip", Body: b64}, string(gzipped)},
+		{"raw compressed body", Payload{ContentEncoding: "deflate", Body: json.RawMessage(`"not base64!"`)}, "not base64!"},
+		{"identity isn't base64 decoded", Payload{ContentEncoding: "identity", Body: json.RawMessage(`"YT0x"`)}, "YT0x"},
+	}
+
+	for _, tt := range tests {
+		if got := string(tt.payload.wireBody()); got != tt.want {
+			t.Errorf("%s: wireBody() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompressedBodyChecks(t *testing.T) {
+	body := `{"type":"charge.succeeded"}`
+	gzipped, _ := encodeBody("gzip", []byte(body))
+	s, _ := json.Marshal(base64.StdEncoding.EncodeToString(gzipped))
+	headers := map[string]string{
+		"content-encoding":    "gzip",
+		"content-type":        "application/json",
+		"x-hub-signature-256": Sign(testSecret, []byte(body)),
+	}
+
+	tests := []struct {
+		name   string
+		route  Route
+		reason string
+	}{
+		{"signature", Route{Secret: testSecret}, ""},
+		{"signature decompressed", Route{Secret: testSecret, Decompress: true}, ""},
+		{"type from body", Route{EventTypeFrom: "body:.type", Include: []string{"charge.succeeded"}}, ""},
+		{"max body bytes", Route{MaxBodyBytes: len(body)}, ""},
+		{"over max body bytes", Route{MaxBodyBytes: len(body) - 1}, "body too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			tt.route.Target = target.URL
+			f := newTestFwder(t, tt.route)
+			result, err := f.ForwardEvent(smeeEvent("1", headers, string(s)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDecompressCorruptBody(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, Decompress: true})
+
+	s, _ := json.Marshal(base64.StdEncoding.EncodeToString([]byte("not gzip")))
+	if _, err := f.ForwardEvent(smeeEvent("1", map[string]string{"content-encoding": "gzip"}, string(s))); err != nil {
+		t.Fatal(err)
+	}
+
+	r := target.received()[0]
+	if r.Header.Get("Content-Encoding") != "gzip" || r.Body != "not gzip" {
+		t.Errorf("target received %q with content-encoding %q, want the body as received", r.Body, r.Header.Get("Content-Encoding"))
+	}
+}
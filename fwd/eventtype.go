@@ -0,0 +1,55 @@
+package fwd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Where Route.EventTypeFrom finds the event type.
+const (
+	typeFromEvent  = "event"
+	typeFromHeader = "header:"
+	typeFromBody   = "body:"
+)
+
+// defaultTypeHeaders are the provider headers checked for the event type when
+// the route doesn't say where to find it.
+var defaultTypeHeaders = []string{"x-github-event", "x-gitlab-event"}
+
+// eventType returns the event's type as configured by the route's
+// EventTypeFrom, by default a provider's event header or else the SSE event
+// name.
+func (r Route) eventType(ev SSEvent, p Payload) string {
+	from := r.EventTypeFrom
+	switch {
+	case from == typeFromEvent:
+		return strings.TrimSpace(ev.Name)
+	case strings.HasPrefix(from, typeFromHeader):
+		return strings.TrimSpace(p.Header(strings.TrimPrefix(from, typeFromHeader)))
+	case strings.HasPrefix(from, typeFromBody):
+		s, _ := lookupJSONString(p.Body, strings.TrimPrefix(from, typeFromBody))
+		return strings.TrimSpace(s)
+	}
+
+	for _, h := range defaultTypeHeaders {
+		if t := p.Header(h); t != "" {
+			return strings.TrimSpace(t)
+		}
+	}
+	return strings.TrimSpace(ev.Name)
+}
+
+// checkEventTypeFrom returns an error if the route's EventTypeFrom isn't one
+// of the forms it understands.
+func (r Route) checkEventTypeFrom() error {
+	from := r.EventTypeFrom
+	switch {
+	case from == "" || from == typeFromEvent:
+		return nil
+	case strings.HasPrefix(from, typeFromHeader) && len(from) > len(typeFromHeader):
+		return nil
+	case strings.HasPrefix(from, typeFromBody) && len(from) > len(typeFromBody):
+		return nil
+	}
+	return fmt.Errorf("%q is not event, header:<name> or body:<path>", from)
+}
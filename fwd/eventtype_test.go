@@ -0,0 +1,52 @@
+package fwd
+
+import "testing"
+
+func TestEventType(t *testing.T) {
+	ev := SSEvent{Id: "1", Name: "message"}
+	p := Payload{
+		Headers: map[string]string{"X-GitHub-Event": "push", "X-Gitlab-Event": "Merge Request Hook", "X-Kind": " deploy "},
+		Body:    []byte(`{"type":"invoice.paid","data":{"object":{"type":"invoice"}}}`),
+	}
+
+	tests := []struct {
+		from    string
+		payload Payload
+		want    string
+	}{
+		{"", p, "push"},
+		{"", Payload{Headers: map[string]string{"x-gitlab-event": "Push Hook"}}, "Push Hook"},
+		{"", Payload{}, "message"},
+		{"event", p, "message"},
+		{"header:X-Gitlab-Event", p, "Merge Request Hook"},
+		{"header:x-kind", p, "deploy"},
+		{"header:X-Missing", p, ""},
+		{"body:.type", p, "invoice.paid"},
+		{"body:data.object.type", p, "invoice"},
+		{"body:.missing", p, ""},
+	}
+
+	for _, tt := range tests {
+		if got := (Route{EventTypeFrom: tt.from}).eventType(ev, tt.payload); got != tt.want {
+			t.Errorf("eventType() from %q = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestEventTypeFiltersAndRoutes(t *testing.T) {
+	invoices := newTestTarget(t)
+	f := newTestFwder(t, Route{
+		TargetsByType: map[string][]string{"invoice.paid": {invoices.URL}},
+		EventTypeFrom: "body:.type",
+		Deny:          []string{"customer.created"},
+	})
+
+	f.ForwardEvent(smeeEvent("1", nil, `{"type":"invoice.paid"}`))
+	result, _ := f.ForwardEvent(smeeEvent("2", nil, `{"type":"customer.created"}`))
+	if result.Reason != "filtered type" {
+		t.Errorf("result = %+v, want the denied type filtered", result)
+	}
+	if got := invoices.received(); len(got) != 1 || got[0].Body != `{"type":"invoice.paid"}` {
+		t.Errorf("received %+v, want the invoice event", got)
+	}
+}
@@ -0,0 +1,65 @@
+package fwd_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/roryq/fwd/fwd"
+	"github.com/thejerf/suture/v4"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+)
+
+func ExampleNewFwder() {
+	// a source relaying one webhook, then holding the stream open
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "id: 1\ndata: {\"x-github-event\":\"push\",\"body\":{\"ref\":\"main\"}}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer source.Close()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		fmt.Printf("%s %s\n", r.Header.Get("X-Github-Event"), b)
+	}))
+	defer target.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f, err := fwd.NewFwder(source.URL, fwd.Route{Target: target.URL},
+		fwd.WithDeliveredHandler(func(fwd.SSEvent) { cancel() }),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	supervisor := suture.NewSimple("webhooks")
+	supervisor.Add(f)
+	supervisor.Serve(ctx)
+	// Output: push {"ref":"main"}
+}
+
+func ExampleFwder_ForwardEvent() {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	f, err := fwd.NewFwder("https://smee.io/abc123", fwd.Route{Target: target.URL, Include: []string{"push"}})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, event := range []string{"push", "issues"} {
+		result, err := f.ForwardEvent(fwd.SSEvent{Id: event, Data: []byte(`{"x-github-event":"` + event + `","body":{}}`)})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(event, result.Outcome)
+	}
+	// Output:
+	// push delivered
+	// issues skipped
+}
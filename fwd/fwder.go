@@ -0,0 +1,1187 @@
+package fwd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thejerf/suture/v4"
+	"golang.org/x/time/rate"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// NewFwder returns a Fwder that subscribes to the source and forwards its
+// events as configured by the route. It returns an error listing the route's
+// problems if the source or route, as checked by Validate, are invalid.
+func NewFwder(source string, route Route, opts ...Option) (*Fwder, error) {
+	if errs := route.Validate(source); len(errs) > 0 {
+		return nil, routeErrors(errs)
+	}
+
+	route = route.WithDefaults()
+	f := &Fwder{
+		id:           RouteID(source, route),
+		source:       source,
+		targets:      route.AllTargets(),
+		route:        route,
+		log:          stdLogger{},
+		queue:        make(chan SSEvent, route.BufferSize),
+		stop:         make(chan interface{}),
+		cutoff:       context.Background(),
+		drainTimeout: DefaultDrainTimeout,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.registerer != nil {
+		if err := registerMetrics(f.registerer); err != nil {
+			return nil, err
+		}
+	}
+	f.log = f.log.With("source", source)
+	if route.Debug {
+		f.log = verbose(f.log)
+	}
+
+	proxy, err := route.proxy()
+	if err != nil {
+		return nil, err
+	}
+	f.proxy = proxy
+
+	if f.client == nil {
+		tlsConfig, err := route.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		localAddr, err := route.localAddr()
+		if err != nil {
+			return nil, err
+		}
+
+		f.client = &http.Client{
+			Timeout: time.Duration(route.Timeout),
+			Transport: &http.Transport{
+				Proxy: proxy,
+				DialContext: (&net.Dialer{
+					// This is the TCP connect timeout in this instance.
+					Timeout:   time.Duration(route.DialTimeout),
+					LocalAddr: localAddr,
+				}).DialContext,
+				TLSHandshakeTimeout: time.Duration(route.TLSHandshakeTimeout),
+				TLSClientConfig:     tlsConfig,
+			},
+		}
+	}
+
+	if route.Transform != "" {
+		if f.transform, err = parseTransform(route.Transform); err != nil {
+			return nil, err
+		}
+	}
+
+	if route.HardLimit > 0 {
+		f.limiter = newWindowLimiter(route.HardLimit, time.Duration(route.HardLimitWindow))
+	}
+
+	if route.BreakerThreshold > 0 {
+		f.breakers = make(map[string]*circuitBreaker, len(f.targets))
+		for _, target := range f.targets {
+			f.breakers[target] = f.newCircuitBreaker(target)
+		}
+	}
+
+	if route.DedupSize > 0 {
+		f.delivered = newIDCache(route.DedupSize)
+	}
+
+	f.deliveries = make(map[string]chan delivery, len(f.targets))
+	for _, target := range f.targets {
+		f.deliveries[target] = make(chan delivery, route.BufferSize)
+	}
+
+	if route.RateLimit > 0 {
+		f.rates = make(map[string]*rate.Limiter, len(f.targets))
+		for _, target := range f.targets {
+			f.rates[target] = rate.NewLimiter(rate.Limit(route.RateLimit), route.RateBurst)
+		}
+	}
+
+	return f, nil
+}
+
+// Fwder forwards the events from a source to its route's targets.
+type Fwder struct {
+	id      string
+	source  string
+	targets []string
+	route   Route
+	client  *http.Client
+	log     Logger
+
+	// proxy chooses the proxy for both the subscription and forwards
+	proxy func(*http.Request) (*url.URL, error)
+
+	// filters skip any event that one of them returns false for
+	filters []func(SSEvent, Payload) bool
+
+	// store records events until they are delivered, auditLog and recent
+	// record their outcomes, and accessLog records every forward attempt, if
+	// set
+	store     *EventStore
+	accessLog *AccessLog
+	auditLog  *AuditLog
+	recent    *RecentEvents
+
+	// dryRun logs forwards instead of sending them
+	dryRun bool
+
+	// onResponse is called with every response from a target, and
+	// onDelivered with every event delivered to all of its targets, if set
+	onResponse  func(Response)
+	onDelivered func(SSEvent)
+
+	// inFlight bounds forwards in flight across fwders, if set
+	inFlight *InFlightLimit
+
+	// eventLimit bounds events forwarded across fwders, if set
+	eventLimit *EventLimit
+
+	// registerer has the metrics registered with it, if set
+	registerer prometheus.Registerer
+
+	// drainTimeout bounds draining the queue when stopping, after which
+	// cutoff is cancelled to abandon in-flight forwards and queued events
+	drainTimeout time.Duration
+	cutoff       context.Context
+
+	// transform rewrites bodies before forwarding, if the route has one
+	transform *template.Template
+
+	// limiter drops events over the route's hard limit, if one is set
+	limiter *windowLimiter
+
+	// delivered holds recently delivered event ids, to skip duplicates
+	delivered *idCache
+
+	// breakers stop forwarding to targets that keep failing, if enabled
+	breakers map[string]*circuitBreaker
+
+	// rates pace the forwards to each target, if the route has a rate limit
+	rates map[string]*rate.Limiter
+
+	// queue holds received events until a worker prepares them, so a slow
+	// target doesn't stall reading from the source, and deliveries holds the
+	// prepared events for each target, so a slow target doesn't hold up the
+	// others
+	queue      chan SSEvent
+	deliveries map[string]chan delivery
+
+	// sub is the current subscription to the source
+	mu  sync.Mutex
+	sub Source
+
+	// replayed is set once the undelivered events from the store have been
+	// queued, so that restarts of Serve don't queue them again
+	replayed bool
+
+	// stop is closed by Stop, once
+	stop     chan interface{}
+	stopOnce sync.Once
+}
+
+// ID returns the route's id, which defaults to the last path segment of the
+// source.
+func (f *Fwder) ID() string {
+	return f.id
+}
+
+// Route returns the route being served, with defaults applied.
+func (f *Fwder) Route() Route {
+	return f.route
+}
+
+// Status returns the state of the connection to the source.
+func (f *Fwder) Status() SubscriptionStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sub == nil {
+		return SubscriptionStatus{}
+	}
+	return f.sub.Status()
+}
+
+func (f *Fwder) Serve(ctx context.Context) error {
+	sub := f.newSource()
+	f.mu.Lock()
+	f.sub = sub
+	f.mu.Unlock()
+	name := fmt.Sprintf("Fwder for %s to %s", f.source, strings.Join(f.targets, ", "))
+	f.log.Infof(name)
+	super := suture.NewSimple(name)
+	super.Add(sub)
+	super.ServeBackground(ctx)
+
+	cutoff, abandon := context.WithCancel(context.Background())
+	defer abandon()
+	f.cutoff = cutoff
+
+	// the queue's workers feed each target's, so they stop first
+	var workers, deliverers sync.WaitGroup
+	draining, deliveriesDraining := make(chan struct{}), make(chan struct{})
+	for i := 0; i < f.route.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			f.work(draining)
+		}()
+		for _, q := range f.deliveries {
+			deliverers.Add(1)
+			go func(q chan delivery) {
+				defer deliverers.Done()
+				f.workDeliveries(q, deliveriesDraining)
+			}(q)
+		}
+	}
+	stopWorkers := func() {
+		close(draining)
+		workers.Wait()
+		close(deliveriesDraining)
+		deliverers.Wait()
+	}
+
+	if !f.replayed {
+		f.replayed = true
+		for _, ev := range f.store.pending(f.source) {
+			f.log.With("event_id", ev.Id).Infof("Replaying undelivered event %s", ev.Id)
+			f.enqueue(ctx, ev)
+		}
+	}
+
+	for {
+		select {
+		case event := <-sub.Events():
+			if event.Id != "" {
+				f.store.record(f.log, f.source, event)
+			}
+			f.enqueue(ctx, event)
+		case <-f.stop:
+			sub.Stop()
+			f.drain(stopWorkers, abandon)
+			return suture.ErrTerminateSupervisorTree
+		case <-f.eventLimit.Done():
+			sub.Stop()
+			f.drain(stopWorkers, abandon)
+			return suture.ErrTerminateSupervisorTree
+		case <-ctx.Done():
+			f.drain(stopWorkers, abandon)
+			return ctx.Err()
+		}
+	}
+}
+
+// DefaultDrainTimeout bounds how long queued and in-flight events are given to
+// be forwarded when a fwder stops, unless set with WithDrainTimeout.
+const DefaultDrainTimeout = 5 * time.Second
+
+// errDrainTimeout is why events still queued or in flight at the drain
+// timeout were abandoned.
+var errDrainTimeout = errors.New("not forwarded before the drain timeout")
+
+// drain stops the workers, which finish the queues first, and waits for them
+// to do so. At the drain timeout it cuts off in-flight forwards, and the
+// workers abandon what is left of the queues.
+func (f *Fwder) drain(stopWorkers func(), abandon context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		stopWorkers()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(f.drainTimeout):
+		f.log.Warnf("Gave up draining %s after %s with %d events queued", f.source, f.drainTimeout, len(f.queue))
+		abandon()
+		<-done
+	}
+}
+
+// Stop ends Serve, draining the queue first. It doesn't block, and can be
+// called before Serve or more than once.
+func (f *Fwder) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+// Inject queues a synthetic event as if it had arrived from the source.
+func (f *Fwder) Inject(ctx context.Context, ev SSEvent) {
+	f.log.With("event_id", ev.Id).Infof("Injecting event %s into route %s", ev.Id, f.id)
+	f.enqueue(ctx, ev)
+}
+
+// enqueue adds the event to the queue. When the queue is full it either
+// blocks or drops the oldest queued event, depending on the overflow policy.
+func (f *Fwder) enqueue(ctx context.Context, ev SSEvent) {
+	if f.route.Overflow == overflowDropOldest {
+		for {
+			select {
+			case f.queue <- ev:
+				return
+			default:
+			}
+
+			select {
+			case old := <-f.queue:
+				f.log.With("event_id", old.Id).Warnf("Queue for %s is full, dropping oldest event %s", f.source, old.Id)
+			default:
+			}
+		}
+	}
+
+	select {
+	case f.queue <- ev:
+	case <-ctx.Done():
+	}
+}
+
+// work prepares queued events and hands them to the targets' queues. Once
+// draining is closed it handles whatever is left in the queue and returns.
+func (f *Fwder) work(draining <-chan struct{}) {
+	for {
+		select {
+		case ev := <-f.queue:
+			f.handle(ev)
+		case <-draining:
+			for {
+				select {
+				case ev := <-f.queue:
+					if f.cutoff.Err() != nil {
+						f.abandon(ev)
+						continue
+					}
+					f.handle(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// abandon gives up on a queued event at the drain timeout, writing it to the
+// dead-letter directory if there is one. The store, if any, still has it to
+// replay.
+func (f *Fwder) abandon(ev SSEvent) {
+	log := f.log.With("event_id", ev.Id)
+	f.audit(AuditEntry{
+		Source:  f.source,
+		EventID: ev.Id,
+		Bytes:   len(ev.Data),
+		Outcome: OutcomeFailed,
+		Reason:  errDrainTimeout.Error(),
+	})
+	if f.route.DeadLetterDir == "" {
+		log.Warnf("Abandoned queued event %s: %s", ev.Id, errDrainTimeout)
+		return
+	}
+	f.deadLetter(log, deadLetter{
+		Source:  f.source,
+		Target:  strings.Join(f.targets, ", "),
+		EventID: ev.Id,
+		Time:    time.Now(),
+		Error:   errDrainTimeout.Error(),
+	}, ev.Data)
+}
+
+// handle prepares the event and queues it for each of its targets, marking
+// skipped events delivered in the store. Events over the event limit are left
+// to be replayed.
+func (f *Fwder) handle(ev SSEvent) {
+	fw, res := f.prepare(ev)
+	if fw == nil {
+		if res.Reason != reasonEventLimit {
+			f.store.delivered(f.log, f.source, ev.Id)
+		}
+		return
+	}
+
+	for _, r := range fw.requests {
+		select {
+		case f.deliveries[r.target] <- delivery{fw, r}:
+		case <-f.cutoff.Done():
+			f.abandonDelivery(fw, r)
+		}
+	}
+}
+
+// delivery is a prepared event queued for one of its targets.
+type delivery struct {
+	fw *forward
+	r  request
+}
+
+// workDeliveries delivers the events queued for a target. Once draining is
+// closed it delivers whatever is left in the queue and returns.
+func (f *Fwder) workDeliveries(q chan delivery, draining <-chan struct{}) {
+	for {
+		select {
+		case d := <-q:
+			f.deliverTo(d.fw, d.r)
+		case <-draining:
+			for {
+				select {
+				case d := <-q:
+					if f.cutoff.Err() != nil {
+						f.abandonDelivery(d.fw, d.r)
+						continue
+					}
+					f.deliverTo(d.fw, d.r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// abandonDelivery gives up on delivering an event to a target at the drain
+// timeout, writing it to the dead-letter directory if there is one.
+func (f *Fwder) abandonDelivery(fw *forward, r request) {
+	log := fw.log.With("target", RedactURL(r.target))
+	if f.route.DeadLetterDir == "" {
+		log.Warnf("Abandoned event %s to %s: %s", r.id, RedactURL(r.target), errDrainTimeout)
+	} else {
+		f.deadLetterRequest(log, r, 0, 0, errDrainTimeout)
+	}
+	f.finish(fw, r, 0, false, errDrainTimeout.Error())
+}
+
+// Forward sends the event on to the route's targets. It reports false only if
+// delivery to a target failed, and true if the event was delivered or
+// deliberately skipped.
+func (f *Fwder) Forward(ev SSEvent) bool {
+	_, err := f.ForwardEvent(ev)
+	return err == nil
+}
+
+// Result is the outcome of forwarding an event, one of OutcomeDelivered,
+// OutcomeSkipped with the Reason, or OutcomeFailed. Statuses holds the final
+// response status from each target the event was sent to, zero where no
+// response was received.
+type Result struct {
+	Outcome  string
+	Reason   string
+	Statuses map[string]int
+}
+
+// DeliveryError is the error for an event that couldn't be delivered to some
+// of its targets, with the final status from each of those.
+type DeliveryError struct {
+	EventID string
+	Failed  map[string]int
+}
+
+func (e *DeliveryError) Error() string {
+	targets := make([]string, 0, len(e.Failed))
+	for t, status := range e.Failed {
+		if status == 0 {
+			targets = append(targets, RedactURL(t))
+		} else {
+			targets = append(targets, fmt.Sprintf("%s (%d)", RedactURL(t), status))
+		}
+	}
+	sort.Strings(targets)
+	return fmt.Sprintf("event %s not delivered to %s", e.EventID, strings.Join(targets, ", "))
+}
+
+// skipped records that the event was skipped and returns the result saying
+// why.
+func (f *Fwder) skipped(ev SSEvent, eventType string, size int, reason string) Result {
+	f.auditSkip(ev, eventType, size, reason)
+	return Result{Outcome: OutcomeSkipped, Reason: reason}
+}
+
+// ForwardEvent sends the event on to the route's targets, as Forward does,
+// and returns the outcome. The error is a *DeliveryError if delivery to a
+// target failed.
+func (f *Fwder) ForwardEvent(ev SSEvent) (Result, error) {
+	fw, result := f.prepare(ev)
+	if fw == nil {
+		return result, nil
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range fw.requests {
+		wg.Add(1)
+		go func(r request) {
+			defer wg.Done()
+			f.deliverTo(fw, r)
+		}(r)
+	}
+	wg.Wait()
+	return fw.result()
+}
+
+// forward is an event prepared for delivery to its targets, which each
+// deliver it independently.
+type forward struct {
+	ev        SSEvent
+	eventType string
+	noID      bool
+	log       Logger
+	requests  []request
+
+	// remaining counts the targets yet to finish, statuses holds the final
+	// status from each that has, and failed those it couldn't be delivered to
+	mu        sync.Mutex
+	remaining int
+	statuses  map[string]int
+	failed    map[string]int
+}
+
+// result returns the outcome once every target has finished.
+func (fw *forward) result() (Result, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	result := Result{Outcome: OutcomeDelivered, Statuses: fw.statuses}
+	if len(fw.failed) > 0 {
+		result.Outcome = OutcomeFailed
+		return result, &DeliveryError{EventID: fw.ev.Id, Failed: fw.failed}
+	}
+	return result, nil
+}
+
+// deliverTo delivers the event to one of its targets and records the outcome.
+func (f *Fwder) deliverTo(fw *forward, r request) {
+	status, ok := f.deliver(fw.log.With("target", RedactURL(r.target)), r)
+	f.finish(fw, r, status, ok, "")
+}
+
+// finish records the outcome of delivering the event to a target, with the
+// reason for a failure if it isn't the status. Once every target has
+// finished, an event delivered to all of them is marked as such, and one that
+// failed gives back its event limit slot.
+func (f *Fwder) finish(fw *forward, r request, status int, ok bool, reason string) {
+	outcome := OutcomeDelivered
+	if !ok {
+		outcome = OutcomeFailed
+	}
+	f.audit(AuditEntry{
+		Source:    f.source,
+		Target:    r.url,
+		EventID:   fw.ev.Id,
+		EventType: fw.eventType,
+		Bytes:     len(r.body),
+		Status:    status,
+		Outcome:   outcome,
+		Reason:    reason,
+	})
+
+	fw.mu.Lock()
+	fw.statuses[r.target] = status
+	if !ok {
+		fw.failed[r.target] = status
+	}
+	fw.remaining--
+	done := fw.remaining == 0
+	failed := len(fw.failed) > 0
+	fw.mu.Unlock()
+	if !done {
+		return
+	}
+	if failed {
+		f.eventLimit.release()
+		return
+	}
+
+	if f.delivered != nil && !fw.noID {
+		f.delivered.add(fw.ev.Id)
+	}
+	f.store.delivered(f.log, f.source, fw.ev.Id)
+	f.eventLimit.deliver()
+	if f.onDelivered != nil {
+		f.onDelivered(fw.ev)
+	}
+}
+
+// prepare decides whether the event is forwarded and builds the requests to
+// its targets. Skipped events, and those only logged in a dry run, have no
+// forward but a result saying why.
+func (f *Fwder) prepare(ev SSEvent) (*forward, Result) {
+	log := f.log.With("event_id", ev.Id)
+	noID := ev.Id == "" || ev.Id == "0"
+	if f.route.skips(ev.Name) {
+		log.Debugf("Skipping received event: %s", ev.Format())
+		return nil, Result{Outcome: OutcomeSkipped, Reason: "keepalive"}
+	}
+	if noID && !f.route.ForwardWithoutID {
+		log.Debugf("Skipping received event: %s", ev.Format())
+		return nil, f.skipped(ev, "", len(ev.Data), "no id")
+	}
+
+	if f.delivered != nil && !noID && f.delivered.contains(ev.Id) {
+		log.Debugf("Skipping duplicate event %s", ev.Id)
+		return nil, f.skipped(ev, "", 0, "duplicate")
+	}
+
+	log.Infof("Received event: %s", ev.Format())
+	eventsReceived.WithLabelValues(f.source).Inc()
+
+	p, err := f.payload(ev)
+	if err != nil {
+		log.Warnf("Skipping event %s: not a smee payload, forward it as is with raw: %s: %s", ev.Id, err, truncate(ev.Data))
+		return nil, f.skipped(ev, "", len(ev.Data), "undecodable payload")
+	}
+
+	// the type, size and signature checks see the decoded body, while the
+	// body is forwarded as received unless the route decompresses it
+	decoded := p
+	if p.ContentEncoding != "" {
+		if b, err := decodeBody(p.ContentEncoding, p.Body); err != nil {
+			log.Debugf("error decoding body of event %s, checking it as received: %s", ev.Id, err)
+		} else {
+			decoded.Body, decoded.ContentEncoding = b, ""
+		}
+	}
+
+	t := f.route.eventType(ev, decoded)
+	if !f.route.allows(t) {
+		log.Debugf("Skipping event %s of filtered type %q", ev.Id, t)
+		return nil, f.skipped(ev, t, len(p.Body), "filtered type")
+	}
+
+	for _, filter := range f.filters {
+		if !filter(ev, p) {
+			log.Debugf("Skipping event %s rejected by a filter", ev.Id)
+			return nil, f.skipped(ev, t, len(p.Body), "filtered")
+		}
+	}
+
+	if f.route.MaxEventAge > 0 && p.Timestamp > 0 {
+		if age := time.Since(time.Unix(0, p.Timestamp*int64(time.Millisecond))); age > time.Duration(f.route.MaxEventAge) {
+			log.Debugf("Skipping event %s received %s ago, older than the max age of %s", ev.Id, age.Round(time.Second), time.Duration(f.route.MaxEventAge))
+			return nil, f.skipped(ev, t, len(p.Body), "too old")
+		}
+	}
+
+	if f.route.MaxBodyBytes > 0 && len(decoded.Body) > f.route.MaxBodyBytes {
+		log.Warnf("Skipping event %s: body of %d bytes is over the max of %d", ev.Id, len(decoded.Body), f.route.MaxBodyBytes)
+		return nil, f.skipped(ev, t, len(p.Body), "body too large")
+	}
+
+	if f.route.Secret != "" && !verifySignature(f.route.Secret, decoded.Body, p.Header("x-hub-signature-256"), p.Header("x-hub-signature")) {
+		log.Warnf("Skipping event %s: missing or invalid signature", ev.Id)
+		return nil, f.skipped(ev, t, len(p.Body), "invalid signature")
+	}
+
+	targets := f.route.targetsFor(t)
+	if len(targets) == 0 {
+		log.Debugf("Skipping event %s of type %q, which has no targets", ev.Id, t)
+		return nil, f.skipped(ev, t, len(p.Body), "no targets for type")
+	}
+
+	if f.limiter != nil && !f.limiter.Allow() {
+		log.Warnf("Dropping event %s: over hard limit of %d per %s (%d dropped)",
+			ev.Id, f.limiter.max, f.limiter.window, f.limiter.Dropped())
+		return nil, f.skipped(ev, t, len(p.Body), "over hard limit")
+	}
+
+	if len(p.Body) == 0 && f.route.ForwardQuery && len(p.Params) > 0 {
+		p.Body = json.RawMessage(url.Values(p.Params).Encode())
+		if p.Header("content-type") == "" {
+			p.Headers["content-type"] = "application/x-www-form-urlencoded"
+		}
+	}
+	if len(p.Body) == 0 && f.route.EmptyBody != "" {
+		p.Body = json.RawMessage(f.route.EmptyBody)
+	}
+
+	if f.transform != nil {
+		if b, err := f.transformBody(p, t); err != nil {
+			log.Warnf("error transforming body of event %s, forwarding the original: %s", ev.Id, err)
+		} else {
+			p.Body, p.ContentEncoding = b, ""
+		}
+	}
+
+	body, encoding, err := f.encodeBody(p.Body, p.ContentEncoding)
+	if err != nil {
+		log.Warnf("error re-encoding body of event %s, forwarding as received: %s", ev.Id, err)
+		body, encoding = p.Body, p.ContentEncoding
+	}
+
+	header := http.Header{}
+	for k, v := range p.Headers {
+		if !hopHeaders[http.CanonicalHeaderKey(k)] {
+			header.Add(k, v)
+		}
+	}
+	header.Del("content-encoding")
+	if encoding != "" {
+		header.Add("content-encoding", encoding)
+	}
+	for k, v := range f.route.Headers {
+		header.Set(k, v)
+	}
+	if f.route.ResignSecret != "" {
+		header.Set("x-hub-signature-256", Sign(f.route.ResignSecret, body))
+		// the relayed legacy signature is of the upstream secret
+		if header.Get("x-hub-signature") != "" {
+			header.Set("x-hub-signature", signSHA1(f.route.ResignSecret, body))
+		}
+	}
+	setHeaderCase(header, f.route.HeaderCase)
+
+	method := p.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	requests := make([]request, len(targets))
+	for i, target := range targets {
+		requests[i] = request{id: ev.Id, target: target, url: target, method: method, body: body, header: header}
+		if f.route.ForwardPath || f.route.ForwardQuery {
+			path := ""
+			if f.route.ForwardPath {
+				path = p.Path
+			}
+			u, err := targetURL(target, path, p.query())
+			if err != nil {
+				log.Warnf("error adding path of event %s to %s, forwarding to the target as is: %s", ev.Id, RedactURL(target), err)
+				continue
+			}
+			requests[i].url = u
+		}
+	}
+
+	if f.dryRun {
+		for _, r := range requests {
+			log.With("target", RedactURL(r.target)).Infof("Dry run: would %s event %s of type %q (%d bytes) to %s", method, ev.Id, t, len(body), RedactURL(r.url))
+		}
+		return nil, f.skipped(ev, t, len(body), "dry run")
+	}
+
+	if !f.eventLimit.reserve() {
+		log.Debugf("Leaving event %s undelivered, the event limit has been reached", ev.Id)
+		return nil, Result{Outcome: OutcomeSkipped, Reason: reasonEventLimit}
+	}
+
+	return &forward{
+		ev:        ev,
+		eventType: t,
+		noID:      noID,
+		log:       log,
+		requests:  requests,
+		remaining: len(requests),
+		statuses:  make(map[string]int, len(requests)),
+		failed:    make(map[string]int),
+	}, Result{}
+}
+
+// reasonEventLimit is why events over the event limit aren't forwarded.
+const reasonEventLimit = "over event limit"
+
+// newCircuitBreaker returns a breaker for the target that logs and records
+// its transitions.
+func (f *Fwder) newCircuitBreaker(target string) *circuitBreaker {
+	redacted := RedactURL(target)
+	log := f.log.With("target", redacted)
+	gauge := circuitState.WithLabelValues(f.source, redacted)
+	cooldown := time.Duration(f.route.BreakerCooldown)
+	return newCircuitBreaker(f.route.BreakerThreshold, cooldown, func(state breakerState) {
+		gauge.Set(float64(state))
+		if state == breakerOpen {
+			log.Warnf("Circuit for %s is open, pausing forwards for %s", redacted, cooldown)
+		} else {
+			log.Infof("Circuit for %s is %s", redacted, state)
+		}
+	})
+}
+
+// Response is a target's response to a forward attempt.
+type Response struct {
+	EventID string
+	Target  string
+	Status  int
+	Header  http.Header
+	Body    []byte
+}
+
+// request is an event ready to be forwarded to one of the route's targets.
+type request struct {
+	id string
+	// target is as configured, and identifies the target in logs and
+	// metrics, while url is where the request is actually sent
+	target string
+	url    string
+	method string
+	body   []byte
+	header http.Header
+}
+
+// deliver sends the event to a target, retrying as configured for the route,
+// and returns the last response status and whether the target accepted it.
+// Events that exhaust their attempts are written to the route's dead-letter
+// directory, if set.
+func (f *Fwder) deliver(log Logger, r request) (int, bool) {
+	for attempt := 1; ; attempt++ {
+		if l, ok := f.rates[r.target]; ok {
+			l.Wait(f.cutoff)
+		}
+
+		status, retry, err := f.attempt(log, r)
+		if err == nil && status < 300 {
+			return status, true
+		}
+		if f.cutoff.Err() != nil {
+			err, retry = errDrainTimeout, false
+			if f.route.DeadLetterDir == "" {
+				log.Warnf("Abandoned event %s to %s: %s", r.id, RedactURL(r.target), err)
+			}
+		}
+		if !retry || attempt >= f.route.MaxAttempts {
+			if f.route.DeadLetterDir != "" {
+				f.deadLetterRequest(log, r, attempt, status, err)
+			}
+			return status, false
+		}
+
+		delay := backoff(time.Duration(f.route.RetryDelay), time.Duration(f.route.MaxRetryDelay), attempt)
+		log.Warnf("Retrying event %s to %s in %s (attempt %d of %d)", r.id, RedactURL(r.target), delay, attempt+1, f.route.MaxAttempts)
+		select {
+		case <-time.After(delay):
+		case <-f.cutoff.Done():
+		}
+	}
+}
+
+// deadLetterRequest writes a request that won't be delivered to the route's
+// dead-letter directory.
+func (f *Fwder) deadLetterRequest(log Logger, r request, attempts, status int, err error) {
+	f.deadLetter(log, deadLetter{
+		Source:   f.source,
+		Target:   r.url,
+		EventID:  r.id,
+		Time:     time.Now(),
+		Method:   r.method,
+		Header:   r.header,
+		Attempts: attempts,
+		Status:   status,
+		Error:    errString(err),
+	}, r.body)
+}
+
+// attempt sends the request through the target's circuit breaker, if it has
+// one, failing immediately while the circuit is open.
+func (f *Fwder) attempt(log Logger, r request) (status int, retry bool, err error) {
+	b := f.breakers[r.target]
+	if b == nil {
+		return f.send(log, r)
+	}
+
+	if !b.allow() {
+		log.Debugf("Not forwarding event %s to %s: %s", r.id, RedactURL(r.target), errCircuitOpen)
+		return 0, false, errCircuitOpen
+	}
+
+	status, retry, err = f.send(log, r)
+	if err == nil && status < 300 {
+		b.success()
+	} else {
+		b.failure()
+	}
+	return status, retry, err
+}
+
+// send makes a single attempt at forwarding the body to the target, returning
+// the response status, or the error if there was no response, and whether a
+// failure is worth retrying.
+func (f *Fwder) send(log Logger, r request) (status int, retry bool, err error) {
+	var body io.Reader
+	if r.method != http.MethodGet && r.method != http.MethodHead {
+		body = bytes.NewReader(r.body)
+	}
+
+	// exec and sink targets aren't urls, so their requests have none
+	u := r.url
+	if scheme, _ := splitTarget(r.target); scheme != "" {
+		u = "/"
+	}
+	req, err := http.NewRequestWithContext(f.cutoff, r.method, u, body)
+	if err != nil {
+		log.Errorf("error creating request: %s", err)
+		return 0, false, err
+	}
+	req.Header = r.header.Clone()
+
+	f.inFlight.acquire()
+	defer f.inFlight.release()
+
+	// the access log and labels don't carry the target's credentials
+	redacted := RedactURL(r.target)
+	entry := accessEntry{
+		time:   time.Now(),
+		source: f.source,
+		target: redacted,
+		method: req.Method,
+		path:   RedactURL(req.URL.RequestURI()),
+		bytes:  len(r.body),
+	}
+	resp, err := f.do(req, r.target)
+	entry.duration = time.Since(entry.time)
+	if err != nil {
+		f.accessLog.log(entry)
+		forwardFailures.WithLabelValues(f.source, redacted, "error").Inc()
+		log.Warnf("error forwarding to %s: %s", RedactURL(r.url), redactError(err))
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+
+	entry.status = resp.StatusCode
+	f.accessLog.log(entry)
+	forwardDuration.WithLabelValues(f.source, redacted).Observe(entry.duration.Seconds())
+	if resp.StatusCode > 299 {
+		forwardFailures.WithLabelValues(f.source, redacted, statusClass(resp.StatusCode)).Inc()
+	} else {
+		eventsForwarded.WithLabelValues(f.source, redacted).Inc()
+	}
+
+	var b []byte
+	if resp.StatusCode > 299 || f.onResponse != nil || f.route.ResponseURL != "" {
+		b, _ = ioutil.ReadAll(resp.Body)
+	}
+	if resp.StatusCode > 299 {
+		log := log.With("status", resp.StatusCode)
+		log.Warnf("Target %s rejected event %s with %s: %s", redacted, r.id, resp.Status, f.errorSummary(b))
+		log.Debugf("response body from %s for event %s: %s", redacted, r.id, b)
+	}
+	response := Response{
+		EventID: r.id,
+		Target:  r.target,
+		Status:  resp.StatusCode,
+		Header:  resp.Header,
+		Body:    b,
+	}
+	if f.onResponse != nil {
+		f.onResponse(response)
+	}
+	if f.route.ResponseURL != "" {
+		f.relayResponse(log, response)
+	}
+	return resp.StatusCode, resp.StatusCode >= 500, nil
+}
+
+// backoff returns the delay before the given retry attempt, doubling base
+// each time up to max, with up to 50% jitter either way.
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)+1))
+}
+
+// maxLoggedBody is how much of a body is logged.
+const maxLoggedBody = 512
+
+// truncate returns the body as a string of at most maxLoggedBody bytes.
+func truncate(b []byte) string {
+	if len(b) > maxLoggedBody {
+		return string(b[:maxLoggedBody]) + "..."
+	}
+	return string(b)
+}
+
+// errorSummary returns the route's configured error field from a JSON error
+// response, falling back to the truncated body.
+func (f *Fwder) errorSummary(b []byte) string {
+	if f.route.ErrorField != "" {
+		if s, ok := lookupJSONString(b, f.route.ErrorField); ok {
+			return s
+		}
+	}
+	return truncate(b)
+}
+
+// encodeBody converts the body from the payload's content-encoding to the one
+// configured for the target, returning the new body and encoding.
+func (f *Fwder) encodeBody(body []byte, encoding string) ([]byte, string, error) {
+	if f.route.Decompress && encoding != "" {
+		b, err := decodeBody(encoding, body)
+		if err != nil {
+			return nil, "", err
+		}
+		body, encoding = b, ""
+	}
+
+	if c := f.route.Compress; c != "" && c != "identity" && encoding == "" {
+		b, err := encodeBody(c, body)
+		if err != nil {
+			return nil, "", err
+		}
+		body, encoding = b, c
+	}
+
+	return body, encoding, nil
+}
+
+// setHeaderCase rewrites the given header names from their canonical form to
+// the exact casing listed. The transport writes keys as they are stored in
+// the map, so these are sent verbatim over HTTP/1.1.
+func setHeaderCase(h http.Header, names []string) {
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		if v, ok := h[canonical]; ok && canonical != name {
+			delete(h, canonical)
+			h[name] = v
+		}
+	}
+}
+
+// hopHeaders describe the original request's connection rather than the
+// webhook, so are not forwarded.
+var hopHeaders = map[string]bool{
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// payloadFields are the smee payload's fields that are not headers.
+var payloadFields = map[string]bool{
+	"body":   true,
+	"method": true,
+	"path":   true,
+	"query":  true,
+	"params": true,
+}
+
+type Payload struct {
+	Host            string
+	Connection      string
+	UserAgent       string `json:"user-agent"`
+	AcceptEncoding  string `json:"accept-encoding"`
+	Accept          string
+	ContentType     string `json:"content-type"`
+	ContentEncoding string `json:"content-encoding"`
+	XRequestID      string `json:"x-request-id"`
+	XGithubDelivery string `json:"x-github-delivery"`
+	XGithubEvent    string `json:"x-github-event"`
+	XHubSignature   string `json:"x-hub-signature"`
+	Method          string
+	Path            string
+	Query           queryValues
+	Params          queryValues
+	Body            json.RawMessage
+	Timestamp       int64
+
+	// Headers holds every header of the original request, keyed as received.
+	Headers map[string]string `json:"-"`
+}
+
+// Header returns the value of the named original header, ignoring case.
+func (p Payload) Header(name string) string {
+	for k, v := range p.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// wireBody returns the body as the original request sent it. Bodies that
+// aren't JSON, such as plain text or a compressed body, are carried as a
+// string, which for a compressed body is base64 encoded or else as is.
+func (p Payload) wireBody() []byte {
+	if len(p.Body) == 0 || p.Body[0] != '"' {
+		return p.Body
+	}
+
+	encoded := p.ContentEncoding != "" && !strings.EqualFold(p.ContentEncoding, "identity")
+	if !encoded && strings.Contains(p.ContentType, "json") {
+		return p.Body
+	}
+
+	var s string
+	if err := json.Unmarshal(p.Body, &s); err != nil {
+		return p.Body
+	}
+	if encoded {
+		if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+			return b
+		}
+	}
+	return []byte(s)
+}
+
+// payload decodes the event's smee payload, or for a raw route makes the
+// event's data the body of one.
+func (f *Fwder) payload(ev SSEvent) (Payload, error) {
+	if f.route.Raw {
+		contentType := f.route.RawContentType
+		if contentType == "" {
+			contentType = defaultRawContentType
+		}
+		return Payload{
+			ContentType: contentType,
+			Body:        json.RawMessage(ev.Data),
+			Headers:     map[string]string{"content-type": contentType},
+		}, nil
+	}
+
+	var p Payload
+	if err := json.Unmarshal(ev.Data, &p); err != nil {
+		return Payload{}, err
+	}
+	p.Body = p.wireBody()
+	return p, nil
+}
+
+// UnmarshalJSON decodes a smee payload, in which the original request's
+// headers are top level string fields alongside the body.
+func (p *Payload) UnmarshalJSON(b []byte) error {
+	// alias to avoid recursing back into UnmarshalJSON
+	type payload Payload
+	if err := json.Unmarshal(b, (*payload)(p)); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+
+	p.Headers = make(map[string]string)
+	for k, v := range fields {
+		var s string
+		if payloadFields[k] || json.Unmarshal(v, &s) != nil {
+			continue
+		}
+		p.Headers[k] = s
+	}
+	return nil
+}
@@ -0,0 +1,1161 @@
+package fwd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thejerf/suture/v4"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSource = "https://smee.io/test"
+
+// nopLogger discards everything logged.
+type nopLogger struct{}
+
+func (l nopLogger) With(string, interface{}) Logger { return l }
+func (nopLogger) Debugf(string, ...interface{})     {}
+func (nopLogger) Infof(string, ...interface{})      {}
+func (nopLogger) Warnf(string, ...interface{})      {}
+func (nopLogger) Errorf(string, ...interface{})     {}
+
+// recordLogger records the lines logged, at every level, as "level: msg".
+type recordLogger struct {
+	mu     sync.Mutex
+	lines  []string
+	fields []string
+}
+
+// With records the field and keeps logging to l.
+func (l *recordLogger) With(key string, value interface{}) Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fields = append(l.fields, fmt.Sprintf("%s=%v", key, value))
+	return l
+}
+
+func (l *recordLogger) Debugf(format string, args ...interface{}) {
+	l.record("debug", format, args...)
+}
+
+func (l *recordLogger) Infof(format string, args ...interface{}) {
+	l.record("info", format, args...)
+}
+
+func (l *recordLogger) Warnf(format string, args ...interface{}) {
+	l.record("warn", format, args...)
+}
+
+func (l *recordLogger) Errorf(format string, args ...interface{}) {
+	l.record("error", format, args...)
+}
+
+func (l *recordLogger) record(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+": "+fmt.Sprintf(format, args...))
+}
+
+// logged returns the lines logged so far.
+func (l *recordLogger) logged() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// received is a request made to a testTarget.
+type received struct {
+	Method string
+	URI    string
+	Header http.Header
+	Body   string
+}
+
+// testTarget is a target that records the requests it receives, responding
+// with each of the statuses in turn and then the last one, 200 if none.
+type testTarget struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+func newTestTarget(t *testing.T, statuses ...int) *testTarget {
+	target := &testTarget{statuses: statuses}
+	target.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+
+		target.mu.Lock()
+		target.requests = append(target.requests, received{Method: r.Method, URI: r.RequestURI, Header: r.Header, Body: string(b)})
+		status := http.StatusOK
+		if len(target.statuses) > 0 {
+			status = target.statuses[0]
+			if len(target.statuses) > 1 {
+				target.statuses = target.statuses[1:]
+			}
+		}
+		target.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(target.Close)
+	return target
+}
+
+// received returns the requests made to the target so far.
+func (s *testTarget) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.requests...)
+}
+
+// smeeEvent returns an event carrying a smee payload of the headers and the
+// JSON body.
+func smeeEvent(id string, headers map[string]string, body string) SSEvent {
+	p := map[string]interface{}{"body": json.RawMessage(body)}
+	for k, v := range headers {
+		p[k] = v
+	}
+	b, _ := json.Marshal(p)
+	return SSEvent{Id: id, Data: b}
+}
+
+// newTestFwder returns a fwder for testSource that logs nothing.
+func newTestFwder(t *testing.T, route Route, opts ...Option) *Fwder {
+	t.Helper()
+	f, err := NewFwder(testSource, route, append([]Option{WithLogger(nopLogger{})}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewFwder: %s", err)
+	}
+	return f
+}
+
+func TestHardLimit(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, HardLimit: 2, HardLimitWindow: Duration(time.Hour)})
+
+	var reasons []string
+	for _, id := range []string{"1", "2", "3"} {
+		result, err := f.ForwardEvent(smeeEvent(id, nil, `{}`))
+		if err != nil {
+			t.Fatalf("event %s: %s", id, err)
+		}
+		reasons = append(reasons, result.Reason)
+	}
+
+	if want := []string{"", "", "over hard limit"}; !equalStrings(reasons, want) {
+		t.Errorf("reasons = %q, want %q", reasons, want)
+	}
+	if n := len(target.received()); n != 2 {
+		t.Errorf("target received %d events, want 2", n)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHeaderCase(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the raw request, as an http.Server would canonicalize the names
+	head := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			lines = append(lines, line)
+		}
+		head <- strings.Join(lines, "")
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	}()
+
+	f := newTestFwder(t, Route{Target: "http://" + l.Addr().String() + "/", HeaderCase: []string{"X-GitHub-Event"}})
+	if _, err := f.ForwardEvent(smeeEvent("1", map[string]string{"x-github-event": "push"}, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	h := <-head
+	if !strings.Contains(h, "\r\nX-GitHub-Event: push\r\n") {
+		t.Errorf("request headers don't have the configured casing:\n%s", h)
+	}
+}
+
+func TestEmptyBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		emptyBody string
+		data      string
+		want      string
+	}{
+		{"placeholder", "{}", `{"x-github-event":"ping"}`, "{}"},
+		{"sent empty by default", "", `{"x-github-event":"ping"}`, ""},
+		{"body kept", "{}", `{"body":{"a":1}}`, `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			f := newTestFwder(t, Route{Target: target.URL, EmptyBody: tt.emptyBody})
+			if _, err := f.ForwardEvent(SSEvent{Id: "1", Data: []byte(tt.data)}); err != nil {
+				t.Fatal(err)
+			}
+			if got := target.received()[0].Body; got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	target := newTestTarget(t, 503, 502, 200)
+	f := newTestFwder(t, Route{Target: target.URL, MaxAttempts: 3, RetryDelay: Duration(time.Millisecond)})
+
+	result, err := f.ForwardEvent(smeeEvent("1", nil, `{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != OutcomeDelivered {
+		t.Errorf("outcome = %s, want delivered", result.Outcome)
+	}
+	if n := len(target.received()); n != 3 {
+		t.Errorf("target received %d attempts, want 3", n)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		attempts int
+	}{
+		{"server error", 500, 2},
+		{"client error isn't retried", 400, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t, tt.status)
+			f := newTestFwder(t, Route{Target: target.URL, MaxAttempts: 2, RetryDelay: Duration(time.Millisecond)})
+
+			_, err := f.ForwardEvent(smeeEvent("1", nil, `{}`))
+			var de *DeliveryError
+			if !errors.As(err, &de) || de.Failed[target.URL] != tt.status {
+				t.Errorf("ForwardEvent() error = %v, want a DeliveryError with status %d", err, tt.status)
+			}
+			if n := len(target.received()); n != tt.attempts {
+				t.Errorf("target received %d attempts, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryRedactsTarget(t *testing.T) {
+	target := newTestTarget(t, 500)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	log := &recordLogger{}
+	f := newTestFwder(t, Route{
+		Targets:     []string{target.URL + "?token=abc", down.URL + "?token=abc"},
+		MaxAttempts: 2,
+		RetryDelay:  Duration(time.Millisecond),
+	}, WithLogger(log))
+
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err == nil {
+		t.Fatal("ForwardEvent() error = nil, want a DeliveryError")
+	}
+	log.mu.Lock()
+	lines := append(log.lines, log.fields...)
+	log.mu.Unlock()
+	for _, l := range lines {
+		if strings.Contains(l, "abc") {
+			t.Errorf("logged %q, want the token redacted", l)
+		}
+	}
+	if !containsString(lines, "target="+target.URL+"?token=xxxxx") {
+		t.Errorf("fields = %q, want the redacted target", lines)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 500*time.Millisecond, time.Minute
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, base},
+		{2, 2 * base},
+		{4, 8 * base},
+		{20, max},
+		{1000, max},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			if d := backoff(base, max, tt.attempt); d < tt.delay/2 || d > tt.delay*3/2 {
+				t.Errorf("backoff(%d) = %s, want within 50%% of %s", tt.attempt, d, tt.delay)
+			}
+		}
+	}
+}
+
+func TestForwardHeaders(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL})
+
+	ev := smeeEvent("1", map[string]string{
+		"x-gitlab-token": "token",
+		"x-gitlab-event": "Push Hook",
+		"content-type":   "application/json",
+		"host":           "smee.io",
+		"connection":     "close",
+	}, `{"object_kind":"push"}`)
+	if _, err := f.ForwardEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	r := target.received()[0]
+	for name, want := range map[string]string{"X-Gitlab-Token": "token", "X-Gitlab-Event": "Push Hook", "Content-Type": "application/json"} {
+		if got := r.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if got := r.Header.Get("Connection"); got == "close" {
+		t.Error("hop-by-hop Connection header was forwarded")
+	}
+	if r.Body != `{"object_kind":"push"}` {
+		t.Errorf("body = %q", r.Body)
+	}
+}
+
+func TestForwardStaticHeaders(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, Headers: map[string]string{
+		"authorization": "Bearer s3cret",
+		"X-Api-Key":     "key",
+	}})
+
+	ev := smeeEvent("1", map[string]string{"authorization": "Bearer original", "x-github-event": "push"}, `{}`)
+	if _, err := f.ForwardEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	r := target.received()[0]
+	for name, want := range map[string]string{"Authorization": "Bearer s3cret", "X-Api-Key": "key", "X-Github-Event": "push"} {
+		if got := r.Header.Values(name); len(got) != 1 || got[0] != want {
+			t.Errorf("%s = %q, want only %q", name, got, want)
+		}
+	}
+}
+
+func TestForwardMethod(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		method string
+		body   string
+	}{
+		{"put", `{"method":"PUT","body":{"a":1}}`, "PUT", `{"a":1}`},
+		{"get has no body", `{"method":"GET","body":{"a":1}}`, "GET", ""},
+		{"post by default", `{"body":{"a":1}}`, "POST", `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			f := newTestFwder(t, Route{Target: target.URL})
+			if _, err := f.ForwardEvent(SSEvent{Id: "1", Data: []byte(tt.data)}); err != nil {
+				t.Fatal(err)
+			}
+
+			r := target.received()[0]
+			if r.Method != tt.method || r.Body != tt.body {
+				t.Errorf("got %s with body %q, want %s with body %q", r.Method, r.Body, tt.method, tt.body)
+			}
+		})
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	a, b := newTestTarget(t), newTestTarget(t, 500)
+	f := newTestFwder(t, Route{Targets: []string{a.URL, b.URL}})
+
+	result, err := f.ForwardEvent(smeeEvent("1", nil, `{}`))
+	var de *DeliveryError
+	if !errors.As(err, &de) || len(de.Failed) != 1 || de.Failed[b.URL] != 500 {
+		t.Errorf("ForwardEvent() error = %v, want only %s failed", err, b.URL)
+	}
+	if result.Statuses[a.URL] != 200 {
+		t.Errorf("statuses = %v, want 200 from %s", result.Statuses, a.URL)
+	}
+	if len(a.received()) != 1 || len(b.received()) != 1 {
+		t.Errorf("targets received %d and %d events, want 1 each", len(a.received()), len(b.received()))
+	}
+}
+
+func TestFanOutSlowTarget(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := newTestTarget(t)
+
+	source := newSSEServer(t, "id: 1\ndata: {}\n\nid: 2\ndata: {}\n\nid: 3\ndata: {}\n\n")
+	f, err := NewFwder(source.URL, Route{Targets: []string{slow.URL, fast.URL}, Timeout: Duration(time.Minute)}, WithLogger(nopLogger{}), WithDrainTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Serve(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fast.received()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("fast target received %d of 3 events while the slow one was blocked", len(fast.received()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFilterEvents(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, Include: []string{"push", "ping"}, Deny: []string{"ping"}})
+
+	tests := []struct {
+		ev     SSEvent
+		reason string
+	}{
+		{smeeEvent("1", map[string]string{"x-github-event": "push"}, `{}`), ""},
+		{smeeEvent("2", map[string]string{"x-github-event": "issues"}, `{}`), "filtered type"},
+		{smeeEvent("3", map[string]string{"x-github-event": "ping"}, `{}`), "filtered type"},
+		{SSEvent{Id: "4", Name: "ping", Data: []byte(`{}`)}, "keepalive"},
+	}
+
+	for _, tt := range tests {
+		result, err := f.ForwardEvent(tt.ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Reason != tt.reason {
+			t.Errorf("event %s: reason = %q, want %q", tt.ev.Id, result.Reason, tt.reason)
+		}
+	}
+}
+
+func TestSlowTargetDoesntStallReading(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	// the store records events as they are read from the source
+	store, err := OpenEventStore(filepath.Join(t.TempDir(), "store"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := newSSEServer(t, "id: 1\ndata: {}\n\nid: 2\ndata: {}\n\nid: 3\ndata: {}\n\nid: 4\ndata: {}\n\n")
+	f, err := NewFwder(source.URL, Route{Target: slow.URL, BufferSize: 10, Timeout: Duration(time.Minute)}, WithLogger(nopLogger{}), WithStore(store), WithDrainTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Serve(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(store.pending(source.URL)) < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("read %d of 4 events while the target was blocked", len(store.pending(source.URL)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEnqueueDropOldest(t *testing.T) {
+	f := newTestFwder(t, Route{Target: "http://localhost/", BufferSize: 2, Overflow: "drop-oldest"})
+	for _, id := range []string{"1", "2", "3"} {
+		f.enqueue(context.Background(), SSEvent{Id: id})
+	}
+
+	var ids []string
+	for len(f.queue) > 0 {
+		ids = append(ids, (<-f.queue).Id)
+	}
+	if want := []string{"2", "3"}; !equalStrings(ids, want) {
+		t.Errorf("queued %q, want %q", ids, want)
+	}
+}
+
+func TestEnqueueBlocks(t *testing.T) {
+	f := newTestFwder(t, Route{Target: "http://localhost/", BufferSize: 1})
+	f.enqueue(context.Background(), SSEvent{Id: "1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	f.enqueue(ctx, SSEvent{Id: "2"})
+	if ev := <-f.queue; ev.Id != "1" || len(f.queue) != 0 {
+		t.Errorf("queue kept event %s and %d more, want only event 1", ev.Id, len(f.queue))
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	target := newTestTarget(t)
+	log := &recordLogger{}
+	f := newTestFwder(t, Route{Target: target.URL}, WithDryRun(true), WithLogger(log))
+
+	result, err := f.ForwardEvent(smeeEvent("7", map[string]string{"x-github-event": "push"}, `{"ref":"main"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != OutcomeSkipped || result.Reason != "dry run" {
+		t.Errorf("result = %+v, want skipped for a dry run", result)
+	}
+	if n := len(target.received()); n != 0 {
+		t.Errorf("target received %d requests in a dry run", n)
+	}
+
+	want := fmt.Sprintf(`info: Dry run: would POST event 7 of type "push" (14 bytes) to %s`, target.URL)
+	if lines := log.logged(); !containsString(lines, want) {
+		t.Errorf("logged %q, want %q", lines, want)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRateLimit(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, RateLimit: 20, RateBurst: 2})
+
+	start := time.Now()
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if _, err := f.ForwardEvent(smeeEvent(id, nil, `{}`)); err != nil {
+			t.Fatalf("event %s: %s", id, err)
+		}
+	}
+
+	// a burst of 2, then the other 2 paced at 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("4 events took %s, want them paced to 20 a second after the burst", elapsed)
+	}
+	if n := len(target.received()); n != 4 {
+		t.Errorf("target received %d events, want all 4", n)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ok      bool
+	}{
+		{"slower than the target", time.Second, true},
+		{"faster than the target", 50 * time.Millisecond, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFwder(t, Route{Target: slow.URL, Timeout: Duration(tt.timeout)})
+			_, err := f.ForwardEvent(smeeEvent("1", nil, `{}`))
+			if ok := err == nil; ok != tt.ok {
+				t.Errorf("ForwardEvent() error = %v, want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTimeoutDefaults(t *testing.T) {
+	route := Route{Target: "http://localhost/", DialTimeout: Duration(time.Second)}.WithDefaults()
+	if route.Timeout != Duration(DefaultTimeout) || route.DialTimeout != Duration(time.Second) || route.TLSHandshakeTimeout != Duration(DefaultTLSHandshakeTimeout) {
+		t.Errorf("timeouts = %s, %s, %s, want the defaults for those unset", time.Duration(route.Timeout), time.Duration(route.DialTimeout), time.Duration(route.TLSHandshakeTimeout))
+	}
+}
+
+func TestProxy(t *testing.T) {
+	proxy := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: "http://target.invalid/webhook?a=1", Proxy: proxy.URL})
+
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if r := proxy.received(); len(r) != 1 || r[0].URI != "http://target.invalid/webhook?a=1" {
+		t.Errorf("proxy received %+v, want the request for the target", r)
+	}
+}
+
+func TestMaxEventAge(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		reason    string
+	}{
+		{"recent", now.Add(-time.Minute), ""},
+		{"stale", now.Add(-2 * time.Hour), "too old"},
+		{"no timestamp", time.Time{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			f := newTestFwder(t, Route{Target: target.URL, MaxEventAge: Duration(time.Hour)})
+
+			data := `{"body":{}}`
+			if !tt.timestamp.IsZero() {
+				data = fmt.Sprintf(`{"timestamp":%d,"body":{}}`, tt.timestamp.UnixNano()/int64(time.Millisecond))
+			}
+			result, err := f.ForwardEvent(SSEvent{Id: "1", Data: []byte(data)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		reason string
+	}{
+		{"at the max", `{"a":"12345"}`, ""},
+		{"over the max", `{"a":"123456"}`, "body too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			f := newTestFwder(t, Route{Target: target.URL, MaxBodyBytes: 13})
+
+			result, err := f.ForwardEvent(smeeEvent("1", nil, tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+// newHeldSource returns an SSE source that sends the stream and then holds
+// it open until the client goes away.
+func newHeldSource(t *testing.T, stream string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStop(t *testing.T) {
+	source := newHeldSource(t, "")
+	f, err := NewFwder(source.URL, Route{Target: "http://localhost/"}, WithLogger(nopLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stopping before serving, and more than once, doesn't block or panic
+	f.Stop()
+	f.Stop()
+
+	errs := make(chan error, 1)
+	go func() { errs <- f.Serve(context.Background()) }()
+	select {
+	case err := <-errs:
+		if err != suture.ErrTerminateSupervisorTree {
+			t.Errorf("Serve() = %v, want ErrTerminateSupervisorTree", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve() didn't return after Stop")
+	}
+}
+
+func TestStopWhileServing(t *testing.T) {
+	source := newHeldSource(t, "")
+	f, err := NewFwder(source.URL, Route{Target: "http://localhost/"}, WithLogger(nopLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- f.Serve(context.Background()) }()
+	deadline := time.Now().Add(time.Second)
+	for !f.Status().Connected && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	f.Stop()
+	select {
+	case err := <-errs:
+		if err != suture.ErrTerminateSupervisorTree {
+			t.Errorf("Serve() = %v, want ErrTerminateSupervisorTree", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve() didn't return after Stop")
+	}
+}
+
+func TestSubscriptionStopBeforeServe(t *testing.T) {
+	s := NewSubscription(newHeldSource(t, "").URL, 0)
+	s.log = nopLogger{}
+	s.Stop()
+	s.Stop()
+	if err := s.Serve(context.Background()); err != suture.ErrTerminateSupervisorTree {
+		t.Errorf("Serve() after Stop = %v, want ErrTerminateSupervisorTree", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	target := newTestTarget(t)
+	source := newHeldSource(t, "id: 1\ndata: {}\n\nid: 2\ndata: {}\n\nid: 3\ndata: {}\n\n")
+	f, err := NewFwder(source.URL, Route{Target: target.URL, RateLimit: 20}, WithLogger(nopLogger{}), WithDrainTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Serve(ctx)
+		close(done)
+	}()
+
+	// shut down once the first event is delivered, with the rest queued
+	deadline := time.Now().Add(time.Second)
+	for len(target.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if n := len(target.received()); n != 3 {
+		t.Errorf("target received %d events before shutting down, want all 3", n)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	release := make(chan struct{})
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer blocked.Close()
+	defer close(release)
+
+	dir := t.TempDir()
+	source := newHeldSource(t, "id: 1\ndata: {}\n\nid: 2\ndata: {}\n\n")
+	f, err := NewFwder(source.URL, Route{Target: blocked.URL, Timeout: Duration(time.Minute), DeadLetterDir: dir}, WithLogger(nopLogger{}), WithDrainTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Serve(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !f.Status().Connected && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve() didn't return at the drain timeout")
+	}
+
+	letters := readDeadLetters(t, dir)
+	if len(letters) != 2 {
+		t.Fatalf("%d dead letters written, want both events", len(letters))
+	}
+	for _, l := range letters {
+		if l.Error != errDrainTimeout.Error() {
+			t.Errorf("dead letter for event %s has error %q, want %q", l.EventID, l.Error, errDrainTimeout)
+		}
+	}
+}
+
+func TestSkipEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  Route
+		ev     SSEvent
+		reason string
+	}{
+		{"ping by default", Route{}, SSEvent{Id: "1", Name: "ping", Data: []byte(`{}`)}, "keepalive"},
+		{"configured keepalive", Route{SkipEvents: []string{"heartbeat"}}, SSEvent{Id: "1", Name: "heartbeat", Data: []byte(`{}`)}, "keepalive"},
+		{"ping forwarded when not a keepalive", Route{SkipEvents: []string{}}, SSEvent{Id: "1", Name: "ping", Data: []byte(`{}`)}, ""},
+		{"empty id", Route{}, SSEvent{Data: []byte(`{}`)}, "no id"},
+		{"id 0", Route{}, SSEvent{Id: "0", Data: []byte(`{}`)}, "no id"},
+		{"empty id forwarded", Route{ForwardWithoutID: true}, SSEvent{Data: []byte(`{}`)}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			tt.route.Target = target.URL
+			f := newTestFwder(t, tt.route)
+
+			result, err := f.ForwardEvent(tt.ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestForwardWithoutIDIsntDeduplicated(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, ForwardWithoutID: true})
+	for i := 0; i < 2; i++ {
+		if _, err := f.ForwardEvent(SSEvent{Data: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(target.received()); n != 2 {
+		t.Errorf("target received %d id-less events, want 2", n)
+	}
+}
+
+func TestRejectedForwardLogged(t *testing.T) {
+	tests := []struct {
+		status int
+		warn   bool
+	}{
+		{http.StatusUnauthorized, true},
+		{http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"message":"bad credentials"}`))
+			}))
+			defer target.Close()
+
+			log := &recordLogger{}
+			f := newTestFwder(t, Route{Target: target.URL}, WithLogger(log))
+			f.ForwardEvent(smeeEvent("9", nil, `{}`))
+
+			want := fmt.Sprintf(`warn: Target %s rejected event 9 with 401 Unauthorized: {"message":"bad credentials"}`, target.URL)
+			lines := log.logged()
+			if tt.warn && !containsString(lines, want) {
+				t.Errorf("logged %q, want %q", lines, want)
+			}
+			for _, l := range lines {
+				if !tt.warn && strings.HasPrefix(l, "warn: ") {
+					t.Errorf("logged %q at warn for a %d", l, tt.status)
+				}
+			}
+			if n := testutil.ToFloat64(forwardFailures.WithLabelValues(testSource, target.URL, "4xx")); (n == 1) != tt.warn {
+				t.Errorf("4xx failures = %v, want counted: %v", n, tt.warn)
+			}
+		})
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to 127.0.0.2 needs the whole of 127/8 on loopback")
+	}
+
+	remote := make(chan string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remote <- host
+	}))
+	defer target.Close()
+
+	f := newTestFwder(t, Route{Target: target.URL, LocalAddr: "127.0.0.2"})
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if host := <-remote; host != "127.0.0.2" {
+		t.Errorf("forwarded from %s, want 127.0.0.2", host)
+	}
+}
+
+func TestTargetsByType(t *testing.T) {
+	ci := newTestTarget(t)
+	bot := newTestTarget(t)
+	fallback := newTestTarget(t)
+
+	tests := []struct {
+		name      string
+		route     Route
+		eventType string
+		target    *testTarget
+	}{
+		{"push", Route{Target: fallback.URL, TargetsByType: map[string][]string{"push": {ci.URL}, "issues": {bot.URL}}}, "push", ci},
+		{"issues", Route{Target: fallback.URL, TargetsByType: map[string][]string{"push": {ci.URL}, "issues": {bot.URL}}}, "issues", bot},
+		{"fallback", Route{Target: fallback.URL, TargetsByType: map[string][]string{"push": {ci.URL}, "issues": {bot.URL}}}, "star", fallback},
+		{"no fallback", Route{TargetsByType: map[string][]string{"push": {ci.URL}}}, "star", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := map[*testTarget]int{ci: len(ci.received()), bot: len(bot.received()), fallback: len(fallback.received())}
+			f := newTestFwder(t, tt.route)
+
+			result, err := f.ForwardEvent(smeeEvent("1", map[string]string{"x-github-event": tt.eventType}, `{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.target == nil && (result.Outcome != OutcomeSkipped || result.Reason != "no targets for type") {
+				t.Errorf("result = %+v, want skipped for no targets", result)
+			}
+			for target, n := range before {
+				want := n
+				if target == tt.target {
+					want++
+				}
+				if got := len(target.received()); got != want {
+					t.Errorf("%s received %d requests, want %d", target.URL, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventLimitStopsServing(t *testing.T) {
+	target := newTestTarget(t)
+	source := newHeldSource(t, "id: 1\ndata: {}\n\nid: 2\ndata: {}\n\nid: 3\ndata: {}\n\n")
+	store, _ := openTestStore(t, 10)
+	limit := NewEventLimit(2)
+	f, err := NewFwder(source.URL, Route{Target: target.URL}, WithLogger(nopLogger{}), WithStore(store), WithEventLimit(limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- f.Serve(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != suture.ErrTerminateSupervisorTree {
+			t.Errorf("Serve() = %v, want it to terminate", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() still running past the event limit")
+	}
+
+	if n := len(target.received()); n != 2 {
+		t.Errorf("target received %d events, want 2", n)
+	}
+	// the third event may not have been read before the limit was reached,
+	// but if it was it is left to be replayed
+	for _, id := range pendingIDs(store, source.URL) {
+		if id != "3" {
+			t.Errorf("event %s pending in the store, want only events over the limit", id)
+		}
+	}
+}
+
+func TestEventLimitReleasesFailedEvents(t *testing.T) {
+	target := newTestTarget(t, 500, 200)
+	limit := NewEventLimit(1)
+	f := newTestFwder(t, Route{Target: target.URL, MaxAttempts: 1}, WithEventLimit(limit))
+
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err == nil {
+		t.Fatal("ForwardEvent() to a failing target error = nil")
+	}
+	select {
+	case <-limit.Done():
+		t.Fatal("Done() closed after a failed event")
+	default:
+	}
+
+	result, err := f.ForwardEvent(smeeEvent("2", nil, `{}`))
+	if err != nil || result.Outcome != OutcomeDelivered {
+		t.Fatalf("ForwardEvent() after a failure = %s, %v, want delivered", result.Outcome, err)
+	}
+	select {
+	case <-limit.Done():
+	default:
+		t.Error("Done() not closed once the limit was delivered")
+	}
+}
+
+func TestRawPayloads(t *testing.T) {
+	tests := []struct {
+		name        string
+		route       Route
+		data        string
+		body        string
+		contentType string
+		reason      string
+	}{
+		{"smee payload", Route{}, `{"content-type":"application/json","body":{"a":1}}`, `{"a":1}`, "application/json", ""},
+		{"raw json", Route{Raw: true}, `{"a":1}`, `{"a":1}`, "application/json", ""},
+		{"raw text", Route{Raw: true, RawContentType: "text/plain"}, `hello`, `hello`, "text/plain", ""},
+		{"undecodable", Route{}, `hello`, "", "", "undecodable payload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			log := &recordLogger{}
+			tt.route.Target = target.URL
+			f := newTestFwder(t, tt.route, WithLogger(log))
+
+			result, err := f.ForwardEvent(SSEvent{Id: "1", Data: []byte(tt.data)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+
+			got := target.received()
+			if tt.reason != "" {
+				if len(got) != 0 {
+					t.Errorf("target received %+v, want nothing", got)
+				}
+				want := `warn: Skipping event 1: not a smee payload, forward it as is with raw: invalid character 'h' looking for beginning of value: hello`
+				if lines := log.logged(); !containsString(lines, want) {
+					t.Errorf("logged %q, want %q", lines, want)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Body != tt.body || got[0].Header.Get("Content-Type") != tt.contentType {
+				t.Errorf("received %+v, want %s as %s", got, tt.body, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestForwardEventResult(t *testing.T) {
+	ok := newTestTarget(t)
+	rejecting := newTestTarget(t, http.StatusBadRequest)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name    string
+		targets []string
+		headers map[string]string
+		outcome string
+		reason  string
+		status  int
+		failed  map[string]int
+	}{
+		{"delivered", []string{ok.URL}, nil, OutcomeDelivered, "", 200, nil},
+		{"skipped", []string{ok.URL}, map[string]string{"x-github-event": "issues"}, OutcomeSkipped, "filtered type", 0, nil},
+		{"rejected", []string{rejecting.URL}, nil, OutcomeFailed, "", 400, map[string]int{rejecting.URL: 400}},
+		{"unreachable", []string{down.URL}, nil, OutcomeFailed, "", 0, map[string]int{down.URL: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFwder(t, Route{Targets: tt.targets, Deny: []string{"issues"}})
+			ev := smeeEvent("1", tt.headers, `{}`)
+
+			result, err := f.ForwardEvent(ev)
+			if result.Outcome != tt.outcome || result.Reason != tt.reason {
+				t.Errorf("result = %+v, want %s %q", result, tt.outcome, tt.reason)
+			}
+			if tt.outcome != OutcomeSkipped && result.Statuses[tt.targets[0]] != tt.status {
+				t.Errorf("statuses = %v, want %d", result.Statuses, tt.status)
+			}
+
+			var de *DeliveryError
+			if tt.failed == nil && err != nil {
+				t.Errorf("ForwardEvent() error = %v, want nil", err)
+			}
+			if tt.failed != nil && (!errors.As(err, &de) || de.EventID != "1" || !reflect.DeepEqual(de.Failed, tt.failed)) {
+				t.Errorf("ForwardEvent() error = %v, want a DeliveryError for %v", err, tt.failed)
+			}
+			if got := f.Forward(ev); got != (tt.failed == nil) {
+				t.Errorf("Forward() = %v, want %v", got, tt.failed == nil)
+			}
+		})
+	}
+}
+
+func TestDeliveryErrorMessage(t *testing.T) {
+	err := &DeliveryError{EventID: "7", Failed: map[string]int{
+		"http://b.internal/?token=abc": 503,
+		"http://a.internal/":           0,
+	}}
+	want := "event 7 not delivered to http://a.internal/, http://b.internal/?token=xxxxx (503)"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
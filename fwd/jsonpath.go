@@ -0,0 +1,50 @@
+package fwd
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// lookupJSON finds the value at a dot separated path such as
+// ".error.message" or "items.0.name" in the JSON document b.
+func lookupJSON(b []byte, path string) (interface{}, bool) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, false
+	}
+
+	for _, key := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// lookupJSONString is lookupJSON with the value rendered as a string; strings
+// are returned as-is and anything else as JSON.
+func lookupJSONString(b []byte, path string) (string, bool) {
+	v, ok := lookupJSON(b, path)
+	if !ok {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	s, _ := json.Marshal(v)
+	return string(s), true
+}
@@ -0,0 +1,35 @@
+package fwd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestErrorSummary(t *testing.T) {
+	long := strings.Repeat("x", maxLoggedBody+10)
+
+	tests := []struct {
+		name       string
+		errorField string
+		body       string
+		want       string
+	}{
+		{"present", ".error.message", `{"error":{"message":"bad signature"}}`, "bad signature"},
+		{"without leading dot", "error.message", `{"error":{"message":"bad signature"}}`, "bad signature"},
+		{"array index", ".errors.0.code", `{"errors":[{"code":422}]}`, "422"},
+		{"object", ".error", `{"error":{"code":1}}`, `{"code":1}`},
+		{"absent", ".error.message", `{"message":"nope"}`, `{"message":"nope"}`},
+		{"not json", ".error.message", "Bad Gateway", "Bad Gateway"},
+		{"unset", "", `{"error":{"message":"bad signature"}}`, `{"error":{"message":"bad signature"}}`},
+		{"truncated", ".error", long, long[:maxLoggedBody] + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Fwder{route: Route{ErrorField: tt.errorField}}
+			if got := f.errorSummary([]byte(tt.body)); got != tt.want {
+				t.Errorf("errorSummary(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package fwd
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultHardLimitWindow = time.Second
+
+// windowLimiter caps the number of events allowed in each fixed window of
+// time. Unlike a token bucket, events over the cap are dropped rather than
+// delayed.
+type windowLimiter struct {
+	mu      sync.Mutex
+	max     int
+	window  time.Duration
+	start   time.Time
+	count   int
+	dropped uint64
+}
+
+func newWindowLimiter(max int, window time.Duration) *windowLimiter {
+	return &windowLimiter{
+		max:    max,
+		window: window,
+	}
+}
+
+// Allow reports whether another event fits in the current window, counting
+// the event as dropped if it does not.
+func (l *windowLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.start) >= l.window {
+		l.start = now.Truncate(l.window)
+		l.count = 0
+	}
+
+	if l.count >= l.max {
+		l.dropped++
+		return false
+	}
+
+	l.count++
+	return true
+}
+
+// Dropped returns the total number of events rejected by the limiter.
+func (l *windowLimiter) Dropped() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped
+}
+
+// InFlightLimit bounds the forwards in flight at once across every Fwder it
+// is given to with WithInFlightLimit. Forwards over the limit wait for one to
+// finish.
+type InFlightLimit struct {
+	slots chan struct{}
+}
+
+// NewInFlightLimit returns a limit of max forwards in flight.
+func NewInFlightLimit(max int) *InFlightLimit {
+	return &InFlightLimit{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a slot, and is a no-op on a nil limit.
+func (l *InFlightLimit) acquire() {
+	if l != nil {
+		l.slots <- struct{}{}
+	}
+}
+
+func (l *InFlightLimit) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// EventLimit bounds the events forwarded across every Fwder it is given to
+// with WithEventLimit. Each event takes a slot before it is forwarded, which
+// is given back if the event fails, and once max events have been delivered
+// the fwders stop reading and Done is closed. Events over the limit are left
+// undelivered in the store, to be replayed.
+type EventLimit struct {
+	max       int64
+	taken     int64
+	delivered int64
+	done      chan struct{}
+}
+
+// NewEventLimit returns a limit of max events forwarded.
+func NewEventLimit(max int64) *EventLimit {
+	return &EventLimit{max: max, done: make(chan struct{})}
+}
+
+// reserve takes a slot, reporting false if there are none left. It is always
+// true on a nil limit.
+func (l *EventLimit) reserve() bool {
+	if l == nil {
+		return true
+	}
+
+	for {
+		n := atomic.LoadInt64(&l.taken)
+		if n >= l.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&l.taken, n, n+1) {
+			return true
+		}
+	}
+}
+
+// release gives back the slot of an event that failed.
+func (l *EventLimit) release() {
+	if l != nil {
+		atomic.AddInt64(&l.taken, -1)
+	}
+}
+
+// deliver counts an event delivered with its slot, closing Done at the max.
+func (l *EventLimit) deliver() {
+	if l != nil && atomic.AddInt64(&l.delivered, 1) == l.max {
+		close(l.done)
+	}
+}
+
+// Done is closed once max events have been delivered. It is nil, and so never closed, on
+// a nil limit.
+func (l *EventLimit) Done() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	return l.done
+}
@@ -0,0 +1,142 @@
+package fwd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWindowLimiter(t *testing.T) {
+	l := newWindowLimiter(2, time.Hour)
+
+	var allowed []bool
+	for i := 0; i < 4; i++ {
+		allowed = append(allowed, l.Allow())
+	}
+
+	want := []bool{true, true, false, false}
+	for i := range want {
+		if allowed[i] != want[i] {
+			t.Fatalf("Allow() = %v, want %v", allowed, want)
+		}
+	}
+	if d := l.Dropped(); d != 2 {
+		t.Errorf("Dropped() = %d, want 2", d)
+	}
+}
+
+func TestWindowLimiterResets(t *testing.T) {
+	l := newWindowLimiter(1, 20*time.Millisecond)
+	if !l.Allow() {
+		t.Fatal("first event not allowed")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if !l.Allow() {
+		t.Error("event in the next window not allowed")
+	}
+}
+
+func TestInFlightLimit(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, most int
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer target.Close()
+
+	// two fwders share the limit
+	limit := NewInFlightLimit(2)
+	fwders := []*Fwder{
+		newTestFwder(t, Route{Target: target.URL}, WithInFlightLimit(limit)),
+		newTestFwder(t, Route{Targets: []string{target.URL + "/a", target.URL + "/b"}}, WithInFlightLimit(limit)),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fwders[i%2].ForwardEvent(smeeEvent(strconv.Itoa(i), nil, `{}`))
+		}(i)
+	}
+	wg.Wait()
+
+	if most != 2 {
+		t.Errorf("%d forwards were in flight at once, want the limit of 2", most)
+	}
+}
+
+func TestInFlightLimitNil(t *testing.T) {
+	var l *InFlightLimit
+	l.acquire()
+	l.release()
+}
+
+func TestEventLimit(t *testing.T) {
+	l := NewEventLimit(3)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.reserve() {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != 3 {
+		t.Errorf("%d slots reserved, want 3", reserved)
+	}
+	select {
+	case <-l.Done():
+		t.Error("Done() closed before any event was delivered")
+	default:
+	}
+
+	// a failed event gives its slot to the next
+	l.release()
+	if !l.reserve() {
+		t.Error("reserve() after a release = false")
+	}
+	for i := 0; i < 3; i++ {
+		l.deliver()
+	}
+	select {
+	case <-l.Done():
+	default:
+		t.Error("Done() not closed with every event delivered")
+	}
+}
+
+func TestEventLimitNil(t *testing.T) {
+	var l *EventLimit
+	if !l.reserve() {
+		t.Error("reserve() on a nil limit = false")
+	}
+	l.release()
+	l.deliver()
+	if l.Done() != nil {
+		t.Error("Done() on a nil limit isn't nil")
+	}
+}
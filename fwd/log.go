@@ -0,0 +1,98 @@
+package fwd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Logger is used for everything fwd logs. With returns a logger that adds a
+// structured field, such as source, target or event_id, to each line.
+type Logger interface {
+	With(key string, value interface{}) Logger
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// verbose returns a logger that writes debug lines too, for routes with Debug
+// set. A Logger can do so itself by implementing Verbose() Logger, otherwise
+// its debug lines are written at info.
+func verbose(l Logger) Logger {
+	if v, ok := l.(interface{ Verbose() Logger }); ok {
+		return v.Verbose()
+	}
+	return debugAsInfo{l}
+}
+
+// debugAsInfo writes debug lines at info, for loggers that can't be made
+// verbose.
+type debugAsInfo struct {
+	Logger
+}
+
+func (l debugAsInfo) With(key string, value interface{}) Logger {
+	return debugAsInfo{l.Logger.With(key, value)}
+}
+
+func (l debugAsInfo) Debugf(format string, args ...interface{}) {
+	l.Logger.Infof(format, args...)
+}
+
+// stdLogger is the default Logger, writing info and above to the standard
+// library's log package with any fields appended as key=value. A verbose
+// stdLogger writes debug lines too.
+type stdLogger struct {
+	fields  map[string]interface{}
+	verbose bool
+}
+
+func (l stdLogger) With(key string, value interface{}) Logger {
+	fields := make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return stdLogger{fields: fields, verbose: l.verbose}
+}
+
+func (l stdLogger) Verbose() Logger {
+	l.verbose = true
+	return l
+}
+
+func (l stdLogger) Debugf(format string, args ...interface{}) {
+	if l.verbose {
+		l.write("debug", format, args...)
+	}
+}
+
+func (l stdLogger) Infof(format string, args ...interface{}) {
+	l.write("info", format, args...)
+}
+
+func (l stdLogger) Warnf(format string, args ...interface{}) {
+	l.write("warn", format, args...)
+}
+
+func (l stdLogger) Errorf(format string, args ...interface{}) {
+	l.write("error", format, args...)
+}
+
+func (l stdLogger) write(level, format string, args ...interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: ", level)
+	fmt.Fprintf(&b, format, args...)
+
+	keys := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, l.fields[k])
+	}
+	log.Print(b.String())
+}
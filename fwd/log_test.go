@@ -0,0 +1,72 @@
+package fwd
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// verboseLogger records whether it was made verbose.
+type verboseLogger struct {
+	*recordLogger
+	verbose bool
+}
+
+func (l verboseLogger) Verbose() Logger {
+	l.verbose = true
+	return l
+}
+
+func TestVerbose(t *testing.T) {
+	if l, ok := verbose(verboseLogger{recordLogger: &recordLogger{}}).(verboseLogger); !ok || !l.verbose {
+		t.Error("verbose() didn't use the logger's Verbose method")
+	}
+
+	rec := &recordLogger{}
+	verbose(rec).With("source", testSource).Debugf("parsed %d lines", 2)
+	if lines := rec.logged(); !equalStrings(lines, []string{"info: parsed 2 lines"}) {
+		t.Errorf("logged %q, want the debug line at info", lines)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+
+	l := stdLogger{}.With("target", "http://localhost/").With("event_id", "1")
+	l.Debugf("hidden")
+	l.Warnf("failed with %d", 502)
+	verbose(l).Debugf("shown")
+
+	want := "warn: failed with 502 event_id=1 target=http://localhost/\ndebug: shown event_id=1 target=http://localhost/\n"
+	if got := buf.String(); got != want {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+}
+
+func TestRouteDebug(t *testing.T) {
+	target := newTestTarget(t)
+	for _, debug := range []bool{false, true} {
+		rec := &recordLogger{}
+		f := newTestFwder(t, Route{Target: target.URL, Debug: debug, Include: []string{"push"}}, WithLogger(rec))
+		f.ForwardEvent(smeeEvent("1", map[string]string{"x-github-event": "issues"}, `{}`))
+
+		skipped := false
+		for _, line := range rec.logged() {
+			if strings.HasPrefix(line, "info: Skipping event 1 of filtered type") {
+				skipped = true
+			}
+		}
+		if skipped != debug {
+			t.Errorf("debug %t: logged %q", debug, rec.logged())
+		}
+	}
+}
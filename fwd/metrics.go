@@ -0,0 +1,56 @@
+package fwd
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+)
+
+var (
+	eventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fwd_events_received_total",
+		Help: "Events received from a source, excluding keepalives.",
+	}, []string{"source"})
+
+	eventsForwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fwd_events_forwarded_total",
+		Help: "Events successfully forwarded to a target.",
+	}, []string{"source", "target"})
+
+	forwardFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fwd_forward_failures_total",
+		Help: "Failed forward attempts, by status class or \"error\" if there was no response.",
+	}, []string{"source", "target", "class"})
+
+	forwardDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "fwd_forward_duration_seconds",
+		Help: "Time taken by forward attempts that got a response.",
+	}, []string{"source", "target"})
+
+	activeSubscriptions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fwd_active_subscriptions",
+		Help: "Whether the subscription to a source is connected.",
+	}, []string{"source"})
+
+	circuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fwd_circuit_state",
+		Help: "State of the circuit breaker for a target: 0 closed, 1 open, 2 half-open.",
+	}, []string{"source", "target"})
+)
+
+// registerMetrics registers the metrics, shared by every Fwder, with reg,
+// which may already have them.
+func registerMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{eventsReceived, eventsForwarded, forwardFailures, forwardDuration, activeSubscriptions, circuitState} {
+		var are prometheus.AlreadyRegisteredError
+		if err := reg.Register(c); err != nil && !errors.As(err, &are) {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusClass returns the class of an HTTP status, such as "5xx".
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
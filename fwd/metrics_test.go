@@ -0,0 +1,46 @@
+package fwd
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestWithMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	ok := newTestTarget(t)
+	failing := newTestTarget(t, 503)
+
+	// a second fwder registers the same metrics
+	newTestFwder(t, Route{Target: ok.URL}, WithMetrics(reg))
+	f := newTestFwder(t, Route{Targets: []string{ok.URL + "/?token=s3cret", failing.URL}}, WithMetrics(reg))
+
+	// fails, as one of the targets does
+	f.ForwardEvent(smeeEvent("1", nil, `{}`))
+
+	redacted := ok.URL + "/?token=xxxxx"
+	if n := testutil.ToFloat64(eventsForwarded.WithLabelValues(testSource, redacted)); n != 1 {
+		t.Errorf("events forwarded to %s = %v, want 1", redacted, n)
+	}
+	if n := testutil.ToFloat64(forwardFailures.WithLabelValues(testSource, failing.URL, "5xx")); n != 1 {
+		t.Errorf("5xx failures of %s = %v, want 1", failing.URL, n)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) == 0 {
+		t.Fatal("no metrics registered")
+	}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if strings.Contains(l.GetValue(), "s3cret") {
+					t.Errorf("%s label %s leaks the target's token: %s", mf.GetName(), l.GetName(), l.GetValue())
+				}
+			}
+		}
+	}
+}
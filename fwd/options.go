@@ -0,0 +1,121 @@
+package fwd
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"time"
+)
+
+// Option configures a Fwder.
+type Option func(*Fwder)
+
+// WithLogger sets the logger, which by default writes info and above to the
+// standard library's log package.
+func WithLogger(l Logger) Option {
+	return func(f *Fwder) {
+		f.log = l
+	}
+}
+
+// WithHTTPClient sets the client events are forwarded with, in place of one
+// built from the route's timeout, proxy and TLS settings. Its transport can
+// be used to handle forwards in process rather than over the network.
+func WithHTTPClient(c *http.Client) Option {
+	return func(f *Fwder) {
+		f.client = c
+	}
+}
+
+// WithFilter skips events the filter returns false for, in addition to the
+// route's Include and Deny filters.
+func WithFilter(filter func(SSEvent, Payload) bool) Option {
+	return func(f *Fwder) {
+		f.filters = append(f.filters, filter)
+	}
+}
+
+// WithStore records received events in the store until they are delivered,
+// replaying any undelivered ones when the Fwder starts.
+func WithStore(s *EventStore) Option {
+	return func(f *Fwder) {
+		f.store = s
+	}
+}
+
+// WithAccessLog writes a line to the access log for every forward attempt.
+func WithAccessLog(l *AccessLog) Option {
+	return func(f *Fwder) {
+		f.accessLog = l
+	}
+}
+
+// WithAuditLog records the outcome of every event in the audit log.
+func WithAuditLog(l *AuditLog) Option {
+	return func(f *Fwder) {
+		f.auditLog = l
+	}
+}
+
+// WithRecentEvents keeps the outcomes of the most recent events in r, which
+// may be shared between Fwders.
+func WithRecentEvents(r *RecentEvents) Option {
+	return func(f *Fwder) {
+		f.recent = r
+	}
+}
+
+// WithDryRun logs the forwards that would be made instead of sending them.
+func WithDryRun(dryRun bool) Option {
+	return func(f *Fwder) {
+		f.dryRun = dryRun
+	}
+}
+
+// WithResponseHandler calls the handler with every response from a target,
+// including failed ones.
+func WithResponseHandler(handler func(Response)) Option {
+	return func(f *Fwder) {
+		f.onResponse = handler
+	}
+}
+
+// WithDeliveredHandler calls the handler with every event once it has been
+// delivered to all of its targets. Skipped events aren't delivered.
+func WithDeliveredHandler(handler func(SSEvent)) Option {
+	return func(f *Fwder) {
+		f.onDelivered = handler
+	}
+}
+
+// WithInFlightLimit shares the limit on forwards in flight with the Fwder.
+func WithInFlightLimit(l *InFlightLimit) Option {
+	return func(f *Fwder) {
+		f.inFlight = l
+	}
+}
+
+// WithEventLimit shares the limit on events forwarded with the Fwder.
+func WithEventLimit(l *EventLimit) Option {
+	return func(f *Fwder) {
+		f.eventLimit = l
+	}
+}
+
+// WithMetrics registers the Fwder's metrics with reg, such as
+// prometheus.DefaultRegisterer. They are recorded, but not registered
+// anywhere, without it.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(f *Fwder) {
+		f.registerer = reg
+	}
+}
+
+// WithDrainTimeout sets how long queued and in-flight events are given to be
+// forwarded when the Fwder stops, DefaultDrainTimeout by default. Events
+// left at the timeout are written to the route's dead-letter directory, or
+// else logged.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(f *Fwder) {
+		f.drainTimeout = d
+	}
+}
@@ -0,0 +1,66 @@
+package fwd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Probe checks that each of the route's http(s) targets can be reached,
+// returning the result for each, nil if it can be. A target is probed with a
+// HEAD request, to which any response will do, or with a GET of the route's
+// HealthPath on the target's host, which must succeed. exec and sink targets
+// aren't probed.
+func (f *Fwder) Probe(ctx context.Context) map[string]error {
+	errs := make(map[string]error)
+	for _, target := range f.targets {
+		if scheme, _ := splitTarget(target); scheme != "" {
+			continue
+		}
+		u, err := url.Parse(target)
+		if err != nil {
+			errs[target] = err
+			continue
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			continue
+		}
+		errs[target] = f.probe(ctx, u)
+	}
+	return errs
+}
+
+func (f *Fwder) probe(ctx context.Context, u *url.URL) error {
+	method := http.MethodHead
+	if f.route.HealthPath != "" {
+		method = http.MethodGet
+		health, err := url.Parse(f.route.HealthPath)
+		if err != nil {
+			return err
+		}
+		u = u.ResolveReference(health)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range f.route.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if method == http.MethodGet && resp.StatusCode > 299 {
+		return fmt.Errorf("health check %s returned %s", RedactURL(u.String()), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package fwd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	up := newTestTarget(t, http.StatusNotFound)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	f := newTestFwder(t, Route{Targets: []string{up.URL, down.URL, "sink:events"}})
+	errs := f.Probe(context.Background())
+
+	if len(errs) != 2 {
+		t.Fatalf("Probe() = %v, want results for 2 targets", errs)
+	}
+	if err := errs[up.URL]; err != nil {
+		t.Errorf("Probe(%s) = %v, want any response to do", up.URL, err)
+	}
+	if errs[down.URL] == nil {
+		t.Errorf("Probe(%s) = nil, want an error", down.URL)
+	}
+	if got := up.received(); len(got) != 1 || got[0].Method != http.MethodHead {
+		t.Errorf("received %+v, want a HEAD request", got)
+	}
+}
+
+func TestProbeHealthPath(t *testing.T) {
+	healthy := newTestTarget(t)
+	unhealthy := newTestTarget(t, http.StatusServiceUnavailable)
+
+	f := newTestFwder(t, Route{
+		Targets:    []string{healthy.URL + "/hooks/github", unhealthy.URL + "/hooks/github"},
+		HealthPath: "/healthz",
+		Headers:    map[string]string{"Authorization": "Bearer abc"},
+	})
+	errs := f.Probe(context.Background())
+
+	if err := errs[healthy.URL+"/hooks/github"]; err != nil {
+		t.Errorf("Probe(healthy) = %v, want nil", err)
+	}
+	if err := errs[unhealthy.URL+"/hooks/github"]; err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Probe(unhealthy) = %v, want a 503 error", err)
+	}
+	got := healthy.received()
+	if len(got) != 1 || got[0].Method != http.MethodGet || got[0].URI != "/healthz" {
+		t.Fatalf("received %+v, want GET /healthz", got)
+	}
+	if auth := got[0].Header.Get("Authorization"); auth != "Bearer abc" {
+		t.Errorf("Authorization = %q, want the route's headers", auth)
+	}
+}
@@ -0,0 +1,45 @@
+package fwd
+
+import "sync"
+
+// RecentEvents keeps the outcomes of the most recent events in memory, the
+// same entries as the audit log, for seeing what was received without debug
+// logging.
+type RecentEvents struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	next    int
+	full    bool
+}
+
+// NewRecentEvents returns a RecentEvents that keeps the last size entries.
+func NewRecentEvents(size int) *RecentEvents {
+	if size <= 0 {
+		size = 1
+	}
+	return &RecentEvents{entries: make([]AuditEntry, size)}
+}
+
+func (r *RecentEvents) add(e AuditEntry) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List returns the entries kept, oldest first.
+func (r *RecentEvents) List() []AuditEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]AuditEntry(nil), r.entries[:r.next]...)
+	}
+	return append(append([]AuditEntry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
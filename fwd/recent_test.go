@@ -0,0 +1,21 @@
+package fwd
+
+import "testing"
+
+func TestRecentEvents(t *testing.T) {
+	r := NewRecentEvents(3)
+	if n := len(r.List()); n != 0 {
+		t.Errorf("List() has %d entries, want none", n)
+	}
+
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		r.add(AuditEntry{EventID: id})
+	}
+	var ids []string
+	for _, e := range r.List() {
+		ids = append(ids, e.EventID)
+	}
+	if !equalStrings(ids, []string{"3", "4", "5"}) {
+		t.Errorf("List() = %v, want the last 3, oldest first", ids)
+	}
+}
@@ -0,0 +1,64 @@
+package fwd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// relayedResponse is what is posted to a route's response url.
+type relayedResponse struct {
+	EventID string            `json:"event_id"`
+	Target  string            `json:"target"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	// Body is the response body as JSON if it is JSON, or else as a string
+	Body json.RawMessage `json:"body"`
+}
+
+// relayResponse posts the target's response to the route's response url.
+// Failures are logged but don't fail the forward.
+func (f *Fwder) relayResponse(log Logger, r Response) {
+	relayed := relayedResponse{
+		EventID: r.EventID,
+		Target:  RedactURL(r.Target),
+		Status:  r.Status,
+		Headers: make(map[string]string, len(r.Header)),
+		Body:    r.Body,
+	}
+	for k := range r.Header {
+		relayed.Headers[strings.ToLower(k)] = r.Header.Get(k)
+	}
+	if !json.Valid(r.Body) {
+		relayed.Body, _ = json.Marshal(string(r.Body))
+	}
+	b, err := json.Marshal(relayed)
+	if err != nil {
+		log.Errorf("error encoding response to relay: %s", err)
+		return
+	}
+
+	u := f.route.ResponseURL
+	if scheme, _ := splitTarget(u); scheme != "" {
+		u = "/"
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		log.Errorf("error creating response relay request: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.do(req, f.route.ResponseURL)
+	if err != nil {
+		log.Warnf("error relaying response from %s to %s: %s", relayed.Target, RedactURL(f.route.ResponseURL), redactError(err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		log.Warnf("error relaying response from %s to %s: status %s", relayed.Target, RedactURL(f.route.ResponseURL), resp.Status)
+		return
+	}
+	log.Debugf("Relayed %d response from %s to %s", r.Status, relayed.Target, RedactURL(f.route.ResponseURL))
+}
@@ -0,0 +1,77 @@
+package fwd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// respondingTarget responds with the status, content type and body.
+func respondingTarget(t *testing.T, status int, contentType, body string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestResponseHandler(t *testing.T) {
+	target := respondingTarget(t, http.StatusCreated, "application/json", `{"text":"ok"}`)
+
+	var got Response
+	f := newTestFwder(t, Route{Target: target.URL}, WithResponseHandler(func(r Response) { got = r }))
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.EventID != "1" || got.Target != target.URL || got.Status != 201 || string(got.Body) != `{"text":"ok"}` || got.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("response = %+v, want the target's response", got)
+	}
+}
+
+func TestResponseURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		relayed     string
+	}{
+		{"json body", "application/json", `{"text":"ok"}`, `{"text":"ok"}`},
+		{"text body", "text/plain", "ok", `"ok"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := respondingTarget(t, http.StatusOK, tt.contentType, tt.body)
+			relay := newTestTarget(t)
+			f := newTestFwder(t, Route{Target: target.URL + "/?token=s3cret", ResponseURL: relay.URL})
+			if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			var r relayedResponse
+			if err := json.Unmarshal([]byte(relay.received()[0].Body), &r); err != nil {
+				t.Fatal(err)
+			}
+			if r.EventID != "1" || r.Target != target.URL+"/?token=xxxxx" || r.Status != 200 || r.Headers["content-type"] != tt.contentType {
+				t.Errorf("relayed %+v, want the response with the target redacted", r)
+			}
+			if string(r.Body) != tt.relayed {
+				t.Errorf("relayed body = %s, want %s", r.Body, tt.relayed)
+			}
+		})
+	}
+}
+
+func TestResponseURLFailureDoesntFailForward(t *testing.T) {
+	target := newTestTarget(t)
+	relay := newTestTarget(t, http.StatusInternalServerError)
+	f := newTestFwder(t, Route{Target: target.URL, ResponseURL: relay.URL})
+
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+		t.Errorf("ForwardEvent() = %v, want the forward to succeed", err)
+	}
+}
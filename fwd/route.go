@@ -0,0 +1,674 @@
+package fwd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	redacted = "REDACTED"
+
+	defaultRetryDelay    = 500 * time.Millisecond
+	defaultMaxRetryDelay = time.Minute
+	defaultBufferSize    = 100
+
+	// maxAttempts bounds MaxAttempts, so a failing target doesn't tie up
+	// an event for days
+	maxAttempts = 100
+
+	defaultRawContentType = "application/json"
+
+	// DefaultTimeout, DefaultDialTimeout and DefaultTLSHandshakeTimeout are
+	// used for routes that don't set their own timeouts.
+	DefaultTimeout             = 5 * time.Second
+	DefaultDialTimeout         = 2500 * time.Millisecond
+	DefaultTLSHandshakeTimeout = 2500 * time.Millisecond
+
+	// DefaultConnectTimeout bounds connecting to the source for routes that
+	// don't set their own.
+	DefaultConnectTimeout = 30 * time.Second
+
+	// DefaultMaxReconnectDelay caps the reconnect delay for routes that don't
+	// set their own.
+	DefaultMaxReconnectDelay = 2 * time.Minute
+
+	overflowBlock      = "block"
+	overflowDropOldest = "drop-oldest"
+)
+
+// Route holds the forwarding settings for a single source. In the config it
+// can be given as a plain target string, a list of targets, or an object.
+type Route struct {
+	// ID names the route in the admin API. Defaults to the last path segment
+	// of the source.
+	ID string `json:"id,omitempty"`
+	// Target is where events are forwarded to. Events are also forwarded to
+	// each of Targets, independently of one another. A target is an http(s)
+	// url, "exec:<command>" to run a command with the body on stdin, or
+	// "sink:<name>" for a handler registered with RegisterSink. Exec targets
+	// run the command with sh -c, so only accept them from trusted configs.
+	Target  string   `json:"target,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+
+	// TargetsByType forwards events of a type, such as "push", to their own
+	// targets in place of Target and Targets, which are left as the fallback
+	// for other types. Events with no targets for their type are skipped.
+	TargetsByType map[string][]string `json:"targets_by_type,omitempty"`
+
+	// Group names a group in the config whose settings this route inherits.
+	Group string `json:"group,omitempty"`
+
+	// Enabled set to false keeps the route in the config without running it.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Debug writes the route's debug logs whatever the log level.
+	Debug bool `json:"debug,omitempty"`
+
+	// HardLimit drops events beyond this many per HardLimitWindow, which
+	// defaults to a second.
+	HardLimit       int      `json:"hard_limit,omitempty"`
+	HardLimitWindow Duration `json:"hard_limit_window,omitempty"`
+
+	// RateLimit paces forwards to each target to this many per second,
+	// allowing bursts of up to RateBurst. Unlike HardLimit, events over the
+	// rate wait their turn rather than being dropped.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	RateBurst int     `json:"rate_burst,omitempty"`
+
+	// HeaderCase lists header names to send with exactly this casing rather
+	// than Go's canonical form, e.g. "X-GitHub-Event".
+	HeaderCase []string `json:"header_case,omitempty"`
+
+	// A payload body with a content-encoding is forwarded still encoded, with
+	// its content-encoding header. Decompress removes the encoding before
+	// forwarding. Compress then encodes the body for the target ("gzip" or
+	// "deflate"). Together they convert between the encodings used on either
+	// side.
+	Decompress bool   `json:"decompress,omitempty"`
+	Compress   string `json:"compress,omitempty"`
+
+	// EmptyBody is sent in place of an empty payload body, e.g. "{}".
+	EmptyBody string `json:"empty_body,omitempty"`
+
+	// Transform is a text/template that rewrites the body before forwarding.
+	// It is executed with .Body, the decoded JSON body, .Raw, the body as
+	// text, .Event and .Headers, and can use json to encode a value. If it
+	// fails the original body is forwarded.
+	Transform string `json:"transform,omitempty"`
+
+	// ErrorField is a dot path such as ".error.message" to pick out of JSON
+	// error responses when logging them.
+	ErrorField string `json:"error_field,omitempty"`
+
+	// ResponseURL relays each target response, with its status, headers and
+	// body, as JSON posted to this url. This gets replies back to callers of
+	// request/response style webhooks such as Slack slash commands.
+	ResponseURL string `json:"response_url,omitempty"`
+
+	// HealthPath is the path, relative to each target, that startup probes
+	// GET and expect a 2xx from. Targets are probed with a HEAD otherwise.
+	HealthPath string `json:"health_path,omitempty"`
+
+	// Secret verifies the GitHub HMAC signature of incoming events' decoded
+	// bodies, skipping any that don't match. Verification is disabled when
+	// empty.
+	Secret string `json:"secret,omitempty" redact:"true"`
+
+	// ResignSecret replaces X-Hub-Signature-256, and the legacy
+	// X-Hub-Signature if relayed, with signatures of the forwarded body using
+	// this secret, for targets with their own secret.
+	ResignSecret string `json:"resign_secret,omitempty" redact:"true"`
+
+	// ForwardPath appends the path and query string of the original request,
+	// when the payload includes them, to the target url.
+	ForwardPath bool `json:"forward_path,omitempty"`
+
+	// ForwardQuery merges just the query string of the original request into
+	// the target url's, keeping every value of repeated parameters. It also
+	// sends the request's form params, when the payload includes them, as a
+	// form encoded body in place of an empty one.
+	ForwardQuery bool `json:"forward_query,omitempty"`
+
+	// Headers are set on every forwarded request, replacing any payload
+	// header of the same name, e.g. an Authorization header for a target
+	// behind an auth proxy. Values support environment variables.
+	Headers map[string]string `json:"headers,omitempty" redact:"true"`
+
+	// EventTypeFrom is where to find each event's type, which Include, Deny
+	// and TargetsByType match: "event" for the SSE event name,
+	// "header:<name>" for a header such as "header:X-Gitlab-Event", or
+	// "body:<path>" for a dot path into the JSON body such as "body:.type"
+	// for Stripe. By default it is the X-GitHub-Event or X-Gitlab-Event
+	// header, or else the SSE event name.
+	EventTypeFrom string `json:"event_type_from,omitempty"`
+
+	// SourceHeaders are set on the request that opens the source's stream,
+	// e.g. an Authorization header for a relay that needs one, or an Accept
+	// header replacing text/event-stream. Values support environment
+	// variables.
+	SourceHeaders map[string]string `json:"source_headers,omitempty" redact:"true"`
+
+	// Include and Deny filter events by type, such as "push". An empty Include
+	// allows all types, and Deny takes precedence over Include.
+	Include []string `json:"include,omitempty"`
+	Deny    []string `json:"deny,omitempty"`
+
+	// SkipEvents are the SSE event names the source sends as keepalives,
+	// which are never forwarded. Defaults to "ping"; set it empty to forward
+	// every event.
+	SkipEvents []string `json:"skip_events,omitempty"`
+
+	// Raw forwards each event's data as the body as it is, sent with
+	// RawContentType, for sources that don't wrap requests in a smee payload.
+	// Without it, events that aren't smee payloads are skipped.
+	Raw            bool   `json:"raw,omitempty"`
+	RawContentType string `json:"raw_content_type,omitempty"`
+
+	// ForwardWithoutID forwards events with an empty or "0" id, which are
+	// otherwise skipped as smee.io's connection events. Such events are never
+	// treated as duplicates.
+	ForwardWithoutID bool `json:"forward_without_id,omitempty"`
+
+	// MaxAttempts is how many times to try forwarding an event when the
+	// target can't be reached or returns a 5xx. Retries back off
+	// exponentially from RetryDelay, up to MaxRetryDelay.
+	MaxAttempts   int      `json:"max_attempts,omitempty"`
+	RetryDelay    Duration `json:"retry_delay,omitempty"`
+	MaxRetryDelay Duration `json:"max_retry_delay,omitempty"`
+
+	// Timeout bounds each forward attempt as a whole, including reading the
+	// response, while DialTimeout and TLSHandshakeTimeout bound connecting to
+	// the target. They default to -timeout, -dial-timeout and
+	// -tls-handshake-timeout.
+	Timeout             Duration `json:"timeout,omitempty"`
+	DialTimeout         Duration `json:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout Duration `json:"tls_handshake_timeout,omitempty"`
+
+	// Proxy is the url of a proxy to reach the source and targets through,
+	// overriding the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables.
+	Proxy string `json:"proxy,omitempty"`
+
+	// LocalAddr is the local IP address forwards to targets are made from,
+	// for hosts with more than one interface.
+	LocalAddr string `json:"local_addr,omitempty"`
+
+	// CAFile is a PEM bundle of CAs to trust for targets, in addition to the
+	// system's. CertFile and KeyFile are a client certificate and key for
+	// targets requiring mutual TLS. InsecureSkipVerify disables verifying the
+	// target's certificate altogether.
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	// BreakerThreshold is how many consecutive failed attempts to a target
+	// open its circuit, after which forwards to it fail immediately until
+	// BreakerCooldown has passed and a probe succeeds. Zero disables it.
+	BreakerThreshold int      `json:"breaker_threshold,omitempty"`
+	BreakerCooldown  Duration `json:"breaker_cooldown,omitempty"`
+
+	// DeadLetterDir is where events that fail every attempt are written, one
+	// JSON file per event and target. Defaults to -dead-letter-dir.
+	DeadLetterDir string `json:"dead_letter_dir,omitempty"`
+
+	// BufferSize is how many received events can be queued for forwarding,
+	// by Workers concurrent workers. When the queue is full Overflow decides
+	// whether reading from the source blocks ("block") or the oldest queued
+	// event is dropped ("drop-oldest"). Each target also has a queue of its
+	// own of BufferSize events, with Workers workers, so that a slow target
+	// doesn't hold up the others until its queue fills.
+	BufferSize int    `json:"buffer_size,omitempty"`
+	Workers    int    `json:"workers,omitempty"`
+	Overflow   string `json:"overflow,omitempty"`
+
+	// MaxEventAge skips events whose payload timestamp is older than this,
+	// such as a backlog replayed after a long outage. Events without a
+	// timestamp are always forwarded.
+	MaxEventAge Duration `json:"max_event_age,omitempty"`
+
+	// DedupSize is how many recently delivered event ids are remembered, so
+	// that an event the source sends again is skipped. Negative disables it.
+	DedupSize int `json:"dedup_size,omitempty"`
+
+	// Protocol is how the source is read, "sse" or "websocket". Defaults to
+	// websocket for ws(s) sources and sse otherwise.
+	Protocol string `json:"protocol,omitempty"`
+
+	// IdleTimeout reconnects to the source when nothing, not even a
+	// keepalive, is received from it for this long. Zero never times out.
+	IdleTimeout Duration `json:"idle_timeout,omitempty"`
+
+	// ConnectTimeout bounds connecting to the source, up to its response
+	// headers, after which the stream runs for as long as it lasts.
+	ConnectTimeout Duration `json:"connect_timeout,omitempty"`
+
+	// MaxReconnectDelay caps the delay before reconnecting to a source that
+	// keeps failing, which doubles with each failure in a row.
+	MaxReconnectDelay Duration `json:"max_reconnect_delay,omitempty"`
+
+	// MaxEventSize is the largest SSE line or websocket message accepted
+	// from the source, in bytes.
+	MaxEventSize int `json:"max_event_size,omitempty"`
+
+	// MaxBodyBytes is the largest decoded payload body that is forwarded, in
+	// bytes. Events with larger bodies are skipped. Zero is unlimited.
+	MaxBodyBytes int `json:"max_body_bytes,omitempty"`
+}
+
+// WithDefaults returns the route with defaults applied to unset fields.
+func (r Route) WithDefaults() Route {
+	if r.Target != "" {
+		r.Targets = append([]string{r.Target}, r.Targets...)
+		r.Target = ""
+	}
+	if r.HardLimit > 0 && r.HardLimitWindow == 0 {
+		r.HardLimitWindow = Duration(defaultHardLimitWindow)
+	}
+	if r.RateLimit > 0 && r.RateBurst == 0 {
+		r.RateBurst = 1
+	}
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = 1
+	}
+	if r.RetryDelay == 0 {
+		r.RetryDelay = Duration(defaultRetryDelay)
+	}
+	if r.MaxRetryDelay == 0 {
+		r.MaxRetryDelay = Duration(defaultMaxRetryDelay)
+	}
+	if r.BreakerThreshold > 0 && r.BreakerCooldown == 0 {
+		r.BreakerCooldown = Duration(defaultBreakerCooldown)
+	}
+	if r.Timeout == 0 {
+		r.Timeout = Duration(DefaultTimeout)
+	}
+	if r.DialTimeout == 0 {
+		r.DialTimeout = Duration(DefaultDialTimeout)
+	}
+	if r.TLSHandshakeTimeout == 0 {
+		r.TLSHandshakeTimeout = Duration(DefaultTLSHandshakeTimeout)
+	}
+	if r.ConnectTimeout == 0 {
+		r.ConnectTimeout = Duration(DefaultConnectTimeout)
+	}
+	if r.MaxReconnectDelay == 0 {
+		r.MaxReconnectDelay = Duration(DefaultMaxReconnectDelay)
+	}
+	if r.BufferSize == 0 {
+		r.BufferSize = defaultBufferSize
+	}
+	if r.Workers == 0 {
+		r.Workers = 1
+	}
+	if r.SkipEvents == nil {
+		r.SkipEvents = []string{"ping"}
+	}
+	if r.Overflow == "" {
+		r.Overflow = overflowBlock
+	}
+	if r.DedupSize == 0 {
+		r.DedupSize = defaultDedupSize
+	}
+	if r.MaxEventSize == 0 {
+		r.MaxEventSize = DefaultMaxEventSize
+	}
+	return r
+}
+
+// Validate checks that the source and targets are well formed URLs.
+func (r Route) Validate(source string) []error {
+	var errs []error
+	if u, err := url.Parse(source); err != nil {
+		errs = append(errs, err)
+	} else if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("source %q is not an http(s) or ws(s) url", source))
+	} else if r.Protocol == protocolSSE && (u.Scheme == "ws" || u.Scheme == "wss") {
+		errs = append(errs, fmt.Errorf("source %q is a websocket url but its protocol is %q", source, r.Protocol))
+	}
+	if r.Protocol != "" && r.Protocol != protocolSSE && r.Protocol != protocolWebSocket {
+		errs = append(errs, fmt.Errorf("protocol of %q must be %q or %q, not %q", source, protocolSSE, protocolWebSocket, r.Protocol))
+	}
+
+	r = r.WithDefaults()
+	if len(r.AllTargets()) == 0 {
+		errs = append(errs, fmt.Errorf("source %q has no target", source))
+	}
+	for _, t := range r.AllTargets() {
+		if scheme, rest := splitTarget(t); scheme != "" {
+			if strings.TrimSpace(rest) == "" {
+				errs = append(errs, fmt.Errorf("target %q of %q is missing a command or sink name", t, source))
+			}
+		} else if u, err := url.Parse(t); err != nil {
+			errs = append(errs, err)
+		} else if !u.IsAbs() || u.Host == "" {
+			errs = append(errs, fmt.Errorf("target %q of %q is not an absolute url", t, source))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("target %q of %q is not an http(s), exec or sink url", t, source))
+		}
+	}
+	if scheme, rest := splitTarget(r.ResponseURL); scheme != "" {
+		if strings.TrimSpace(rest) == "" {
+			errs = append(errs, fmt.Errorf("response url %q of %q is missing a command or sink name", r.ResponseURL, source))
+		}
+	} else if r.ResponseURL != "" {
+		if u, err := url.Parse(r.ResponseURL); err != nil {
+			errs = append(errs, err)
+		} else if !u.IsAbs() || u.Host == "" {
+			errs = append(errs, fmt.Errorf("response url %q of %q is not an absolute url", r.ResponseURL, source))
+		}
+	}
+	if r.Proxy != "" {
+		if _, err := r.proxy(); err != nil {
+			errs = append(errs, fmt.Errorf("proxy of %q: %w", source, err))
+		}
+	}
+	if r.LocalAddr != "" {
+		if _, err := r.localAddr(); err != nil {
+			errs = append(errs, fmt.Errorf("local address of %q: %w", source, err))
+		}
+	}
+	if err := r.checkEventTypeFrom(); err != nil {
+		errs = append(errs, fmt.Errorf("event type of %q: %w", source, err))
+	}
+	if r.Transform != "" {
+		if _, err := parseTransform(r.Transform); err != nil {
+			errs = append(errs, fmt.Errorf("transform of %q: %w", source, err))
+		}
+	}
+	if _, err := r.tlsConfig(); err != nil {
+		errs = append(errs, fmt.Errorf("tls config of %q: %w", source, err))
+	}
+	if r.MaxAttempts < 0 || r.MaxAttempts > maxAttempts {
+		errs = append(errs, fmt.Errorf("max attempts of %q must be between 1 and %d, not %d", source, maxAttempts, r.MaxAttempts))
+	}
+	if r.RetryDelay < 0 || r.MaxRetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("retry delays of %q must not be negative", source))
+	}
+	if r.HardLimit < 0 || r.HardLimitWindow < 0 {
+		errs = append(errs, fmt.Errorf("hard limit and window of %q must not be negative", source))
+	}
+	if r.RateLimit < 0 || r.RateBurst < 0 {
+		errs = append(errs, fmt.Errorf("rate limit and burst of %q must not be negative", source))
+	}
+	if r.BufferSize < 0 || r.Workers < 0 {
+		errs = append(errs, fmt.Errorf("buffer size and workers of %q must not be negative", source))
+	}
+	if r.MaxEventSize < 0 {
+		errs = append(errs, fmt.Errorf("max event size of %q must not be negative", source))
+	}
+	switch r.Compress {
+	case "", "identity", "gzip", "deflate":
+	default:
+		errs = append(errs, fmt.Errorf("compress of %q must be \"gzip\", \"deflate\" or \"identity\", not %q", source, r.Compress))
+	}
+	if r.Overflow != "" && r.Overflow != overflowBlock && r.Overflow != overflowDropOldest {
+		errs = append(errs, fmt.Errorf("overflow of %q must be %q or %q, not %q", source, overflowBlock, overflowDropOldest, r.Overflow))
+	}
+	return errs
+}
+
+// localAddr returns the address to dial targets from, nil for the default.
+func (r Route) localAddr() (net.Addr, error) {
+	if r.LocalAddr == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(r.LocalAddr)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address", r.LocalAddr)
+	}
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// routeErrors is the problems Validate found with a route, as one error.
+type routeErrors []error
+
+func (e routeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// AllTargets returns every target of the route, the fallback targets followed
+// by those for each event type, without duplicates.
+func (r Route) AllTargets() []string {
+	r = r.WithDefaults()
+	types := make([]string, 0, len(r.TargetsByType))
+	for t := range r.TargetsByType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	var all []string
+	seen := make(map[string]bool)
+	add := func(targets []string) {
+		for _, target := range targets {
+			if !seen[target] {
+				seen[target] = true
+				all = append(all, target)
+			}
+		}
+	}
+	add(r.Targets)
+	for _, t := range types {
+		add(r.TargetsByType[t])
+	}
+	return all
+}
+
+// IsEnabled reports whether the route should be run, which it is unless
+// Enabled is set to false.
+func (r Route) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// targetsFor returns the targets for events of the type.
+func (r Route) targetsFor(eventType string) []string {
+	if targets, ok := r.TargetsByType[eventType]; ok {
+		return targets
+	}
+	return r.Targets
+}
+
+// skips reports whether events with the SSE event name are keepalives.
+func (r Route) skips(name string) bool {
+	for _, n := range r.SkipEvents {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the route's filters let through events of the type.
+func (r Route) allows(eventType string) bool {
+	for _, t := range r.Deny {
+		if t == eventType {
+			return false
+		}
+	}
+	if len(r.Include) == 0 {
+		return true
+	}
+	for _, t := range r.Include {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the route that is safe to display, with
+// credentials removed from URLs and any field tagged `redact:"true"` masked.
+func (r Route) Redacted() Route {
+	r.Target = RedactURL(r.Target)
+	targets := make([]string, len(r.Targets))
+	for i, t := range r.Targets {
+		targets[i] = RedactURL(t)
+	}
+	r.Targets = targets
+	if r.TargetsByType != nil {
+		byType := make(map[string][]string, len(r.TargetsByType))
+		for t, targets := range r.TargetsByType {
+			for _, target := range targets {
+				byType[t] = append(byType[t], RedactURL(target))
+			}
+		}
+		r.TargetsByType = byType
+	}
+	r.Proxy = RedactURL(r.Proxy)
+	r.ResponseURL = RedactURL(r.ResponseURL)
+
+	v := reflect.ValueOf(&r).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("redact") != "true" {
+			continue
+		}
+
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			if f.Len() > 0 {
+				f.SetString(redacted)
+			}
+		case reflect.Map:
+			if f.IsNil() {
+				continue
+			}
+			m := reflect.MakeMap(f.Type())
+			iter := f.MapRange()
+			for iter.Next() {
+				m.SetMapIndex(iter.Key(), reflect.ValueOf(redacted))
+			}
+			f.Set(m)
+		}
+	}
+	return r
+}
+
+// proxy returns the function used by a transport to choose the proxy for a
+// request, which is the route's proxy if set and otherwise taken from the
+// environment.
+func (r Route) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if r.Proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(r.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute url", r.Proxy)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// RedactURL masks any password and query values in the url, either of which
+// may hold a token. Exec and sink targets are returned as they are.
+func RedactURL(s string) string {
+	if scheme, _ := splitTarget(s); scheme != "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.User == nil && u.RawQuery == "") {
+		return s
+	}
+
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k, vs := range q {
+			for i := range vs {
+				vs[i] = "xxxxx"
+			}
+			q[k] = vs
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactError redacts the url in errors returned by an http.Client.
+func redactError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return &url.Error{Op: ue.Op, URL: RedactURL(ue.URL), Err: ue.Err}
+	}
+	return err
+}
+
+func (r *Route) UnmarshalJSON(b []byte) error {
+	switch b = bytes.TrimSpace(b); {
+	case bytes.HasPrefix(b, []byte(`"`)):
+		return json.Unmarshal(b, &r.Target)
+	case bytes.HasPrefix(b, []byte(`[`)):
+		return json.Unmarshal(b, &r.Targets)
+	}
+
+	// alias to avoid recursing back into UnmarshalJSON
+	type route Route
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*route)(r))
+}
+
+// MarshalJSON writes routes with nothing but targets in the short string or
+// list form, so they round trip to the same config.
+func (r Route) MarshalJSON() ([]byte, error) {
+	switch {
+	case r.Target != "" && reflect.DeepEqual(r, Route{Target: r.Target}):
+		return json.Marshal(r.Target)
+	case len(r.Targets) > 0 && reflect.DeepEqual(r, Route{Targets: r.Targets}):
+		return json.Marshal(r.Targets)
+	}
+
+	type route Route
+	return json.Marshal(route(r))
+}
+
+// Duration is a time.Duration that is written as a string such as "1s" in
+// the config.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1s\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// RouteID returns the route's explicit id, falling back to the last path
+// segment of the source, which for smee.io is the channel name.
+func RouteID(source string, route Route) string {
+	if route.ID != "" {
+		return route.ID
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+		return url.PathEscape(source)
+	}
+	return path.Base(u.Path)
+}
@@ -0,0 +1,51 @@
+package fwd
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+)
+
+// verifySignature checks a GitHub style HMAC signature of the body, using the
+// X-Hub-Signature-256 header if present and the legacy SHA-1 X-Hub-Signature
+// otherwise.
+func verifySignature(secret string, body []byte, sig256, sig1 string) bool {
+	switch {
+	case sig256 != "":
+		return checkMAC(sha256.New, secret, body, strings.TrimPrefix(sig256, "sha256="))
+	case sig1 != "":
+		return checkMAC(sha1.New, secret, body, strings.TrimPrefix(sig1, "sha1="))
+	}
+	return false
+}
+
+// Sign returns the X-Hub-Signature-256 value for the body.
+func Sign(secret string, body []byte) string {
+	return "sha256=" + hexMAC(sha256.New, secret, body)
+}
+
+// signSHA1 returns the legacy X-Hub-Signature value for the body.
+func signSHA1(secret string, body []byte) string {
+	return "sha1=" + hexMAC(sha1.New, secret, body)
+}
+
+// hexMAC returns the hex encoded HMAC of the body.
+func hexMAC(h func() hash.Hash, secret string, body []byte) string {
+	m := hmac.New(h, []byte(secret))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func checkMAC(h func() hash.Hash, secret string, body []byte, sig string) bool {
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
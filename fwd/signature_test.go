@@ -0,0 +1,117 @@
+package fwd
+
+import "testing"
+
+// the example from GitHub's webhook docs
+const (
+	testSecret    = "It's a Secret to Everybody"
+	testBody      = "Hello, World!"
+	testSignature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+)
+
+func TestVerifySignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		sig256 string
+		sig1   string
+		want   bool
+	}{
+		{"valid", testSignature, "", true},
+		{"valid sha1", "", "sha1=01dc10d0c83e72ed246219cdd91669667fe2ca59", true},
+		{"invalid", "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e18", "", false},
+		{"not hex", "sha256=nope", "", false},
+		{"missing", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifySignature(testSecret, []byte(testBody), tt.sig256, tt.sig1); got != tt.want {
+				t.Errorf("verifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardVerifiesSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		reason    string
+	}{
+		{"valid", testSecret, testSignature, ""},
+		{"invalid", testSecret, "sha256=00", "invalid signature"},
+		{"missing", testSecret, "", "invalid signature"},
+		{"no secret", "", "sha256=00", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			f := newTestFwder(t, Route{Target: target.URL, Secret: tt.secret})
+
+			headers := map[string]string{"content-type": "text/plain"}
+			if tt.signature != "" {
+				headers["x-hub-signature-256"] = tt.signature
+			}
+			result, err := f.ForwardEvent(smeeEvent("1", headers, `"`+testBody+`"`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	if got := Sign(testSecret, []byte(testBody)); got != testSignature {
+		t.Errorf("Sign() = %q, want %q", got, testSignature)
+	}
+}
+
+func TestForwardResigns(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, ResignSecret: "local"})
+
+	ev := smeeEvent("1", map[string]string{"x-hub-signature-256": "sha256=upstream", "content-type": "application/json"}, `{"a":1}`)
+	if _, err := f.ForwardEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "sha256=985d4ef2bc22907741f8671db16b8e109d97090b0f9efb7a8778870cc2f32843"
+	if got := target.received()[0].Header.Get("X-Hub-Signature-256"); got != want {
+		t.Errorf("X-Hub-Signature-256 = %q, want %q", got, want)
+	}
+}
+
+func TestForwardResignsLegacySignature(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, ResignSecret: "local"})
+
+	ev := smeeEvent("1", map[string]string{"x-hub-signature": "sha1=upstream", "x-hub-signature-256": "sha256=upstream"}, `{"a":1}`)
+	if _, err := f.ForwardEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	h := target.received()[0].Header
+	if sig := h.Get("X-Hub-Signature"); !verifySignature("local", []byte(`{"a":1}`), "", sig) {
+		t.Errorf("X-Hub-Signature = %q, want it re-signed with the local secret", sig)
+	}
+	if sig := h.Get("X-Hub-Signature-256"); !verifySignature("local", []byte(`{"a":1}`), sig, "") {
+		t.Errorf("X-Hub-Signature-256 = %q, want it re-signed with the local secret", sig)
+	}
+}
+
+func TestForwardResignsWithoutAddingLegacySignature(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL, ResignSecret: "local"})
+
+	if _, err := f.ForwardEvent(smeeEvent("1", map[string]string{"x-hub-signature-256": "sha256=upstream"}, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if sig := target.received()[0].Header.Get("X-Hub-Signature"); sig != "" {
+		t.Errorf("X-Hub-Signature = %q, want none added", sig)
+	}
+}
@@ -0,0 +1,143 @@
+package fwd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Besides http(s) urls, a target can be "exec:<command>" to run a command
+// with the body on stdin, or "sink:<name>" for a handler registered with
+// RegisterSink.
+const (
+	execScheme = "exec"
+	sinkScheme = "sink"
+)
+
+var (
+	sinksMu sync.RWMutex
+	sinks   = make(map[string]http.Handler)
+)
+
+// RegisterSink makes the handler available as the target "sink:<name>", so
+// that events are forwarded to it in process. A response status of 300 or
+// more is a failed forward, as it is for a url.
+func RegisterSink(name string, h http.Handler) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	sinks[name] = h
+}
+
+// splitTarget returns the scheme of an exec or sink target and the command or
+// name after it, taken as is rather than parsed as a url so that characters
+// such as ? and # are kept. Other targets have no scheme.
+func splitTarget(target string) (scheme, rest string) {
+	for _, s := range []string{execScheme, sinkScheme} {
+		if strings.HasPrefix(target, s+":") {
+			return s, strings.TrimPrefix(target, s+":")
+		}
+	}
+	return "", target
+}
+
+// do sends the request to an http(s) target with the client, or else to the
+// exec or sink target.
+func (f *Fwder) do(req *http.Request, target string) (*http.Response, error) {
+	switch scheme, rest := splitTarget(target); scheme {
+	case execScheme:
+		return f.runExec(req, rest)
+	case sinkScheme:
+		return serveSink(req, rest)
+	}
+	return f.client.Do(req)
+}
+
+// runExec runs the command with sh, passing the body on stdin and the headers
+// as CGI style environment variables such as HTTP_X_GITHUB_EVENT. A zero exit
+// status is a successful forward.
+func (f *Fwder) runExec(req *http.Request, command string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), time.Duration(f.route.Timeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), "REQUEST_METHOD="+req.Method)
+	for k, vs := range req.Header {
+		name := "HTTP_" + strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		cmd.Env = append(cmd.Env, name+"="+strings.Join(vs, ", "))
+	}
+	if req.Body != nil {
+		cmd.Stdin = req.Body
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+		}
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(out)),
+		Request:    req,
+	}, nil
+}
+
+// serveSink passes the request to the handler registered as name.
+func serveSink(req *http.Request, name string) (*http.Response, error) {
+	sinksMu.RLock()
+	h, ok := sinks[name]
+	sinksMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no sink registered as %q", name)
+	}
+
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	w := &sinkResponse{header: http.Header{}}
+	h.ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode: w.status,
+		Header:     w.header,
+		Body:       ioutil.NopCloser(&w.body),
+		Request:    req,
+	}, nil
+}
+
+// sinkResponse records a sink handler's response.
+type sinkResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *sinkResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *sinkResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *sinkResponse) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
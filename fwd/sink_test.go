@@ -0,0 +1,88 @@
+package fwd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		scheme string
+		rest   string
+	}{
+		{"exec:./handle.sh --flag", "exec", "./handle.sh --flag"},
+		{"exec:grep -q 'a?b' # comment", "exec", "grep -q 'a?b' # comment"},
+		{"sink:events", "sink", "events"},
+		{"http://localhost:3000/?a=1#top", "", "http://localhost:3000/?a=1#top"},
+	}
+
+	for _, tt := range tests {
+		scheme, rest := splitTarget(tt.target)
+		if scheme != tt.scheme || rest != tt.rest {
+			t.Errorf("splitTarget(%q) = %q, %q, want %q, %q", tt.target, scheme, rest, tt.scheme, tt.rest)
+		}
+	}
+}
+
+func TestSinkTarget(t *testing.T) {
+	var got received
+	RegisterSink("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got = received{Method: r.Method, Header: r.Header, Body: string(b)}
+		if r.Header.Get("X-Github-Event") == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	f := newTestFwder(t, Route{Target: "sink:test"})
+	if _, err := f.ForwardEvent(smeeEvent("1", map[string]string{"x-github-event": "push"}, `{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != "POST" || got.Body != `{"a":1}` || got.Header.Get("X-Github-Event") != "push" {
+		t.Errorf("sink received %+v, want the event", got)
+	}
+
+	if _, err := f.ForwardEvent(smeeEvent("2", map[string]string{"x-github-event": "fail"}, `{}`)); err == nil {
+		t.Error("forward to a sink responding 500 succeeded")
+	}
+}
+
+func TestSinkTargetUnregistered(t *testing.T) {
+	f := newTestFwder(t, Route{Target: "sink:unregistered"})
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err == nil {
+		t.Error("forward to an unregistered sink succeeded")
+	}
+}
+
+func TestExecTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	f := newTestFwder(t, Route{Target: "exec:cat > " + out + "; echo \" $REQUEST_METHOD $HTTP_X_GITHUB_EVENT\" >> " + out})
+	if _, err := f.ForwardEvent(smeeEvent("1", map[string]string{"x-github-event": "push"}, `{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"a\":1} POST push\n"; string(b) != want {
+		t.Errorf("command wrote %q, want %q", b, want)
+	}
+}
+
+func TestExecTargetFails(t *testing.T) {
+	f := newTestFwder(t, Route{Target: "exec:exit 1"})
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err == nil {
+		t.Error("forward to a failing command succeeded")
+	}
+}
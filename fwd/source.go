@@ -0,0 +1,342 @@
+package fwd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/thejerf/suture/v4"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Source is a connection to an event source. It is a suture service that
+// connects each time it is served, and yields the events received on Events.
+type Source interface {
+	suture.Service
+	Events() <-chan SSEvent
+	Stop()
+	Status() SubscriptionStatus
+}
+
+const (
+	protocolSSE       = "sse"
+	protocolWebSocket = "websocket"
+)
+
+// protocol returns the protocol the source is read with, either as set on the
+// route or by the source url's scheme.
+func (r Route) protocol(source string) string {
+	if r.Protocol != "" {
+		return r.Protocol
+	}
+	if strings.HasPrefix(source, "ws://") || strings.HasPrefix(source, "wss://") {
+		return protocolWebSocket
+	}
+	return protocolSSE
+}
+
+// newSource returns a connection to the fwder's source using the route's
+// protocol and proxy.
+func (f *Fwder) newSource() Source {
+	if f.route.protocol(f.source) == protocolWebSocket {
+		ws := NewWebSocketSubscription(f.source, f.route.MaxEventSize)
+		ws.log = f.log
+		ws.header = f.route.SourceHeaders
+		ws.idleTimeout = time.Duration(f.route.IdleTimeout)
+		ws.maxRetry = time.Duration(f.route.MaxReconnectDelay)
+		ws.dialer.Proxy = f.proxy
+		if f.route.ConnectTimeout > 0 {
+			ws.dialer.HandshakeTimeout = time.Duration(f.route.ConnectTimeout)
+		}
+		return ws
+	}
+
+	sub := NewSubscription(f.source, f.route.MaxEventSize)
+	sub.log = f.log
+	sub.header = f.route.SourceHeaders
+	sub.idleTimeout = time.Duration(f.route.IdleTimeout)
+	sub.maxRetry = time.Duration(f.route.MaxReconnectDelay)
+	sub.connectTimeout = time.Duration(f.route.ConnectTimeout)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = f.proxy
+	sub.client.Transport = transport
+	return sub
+}
+
+// connection holds what every protocol's source has in common: the events
+// channel, reconnecting, stopping, and the connection status.
+type connection struct {
+	events chan SSEvent
+	url    string
+	log    Logger
+
+	// cancel ends the current connection, and stopped prevents new ones
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	stopped bool
+
+	// lastEventID is sent when reconnecting so the server can replay missed
+	// events. It is kept across restarts of Serve.
+	lastEventID string
+
+	// retry is the delay before reconnecting, which doubles with each
+	// consecutive failure up to maxRetry
+	retry    time.Duration
+	maxRetry time.Duration
+	failures int
+
+	// header is added to the request that opens the connection
+	header map[string]string
+
+	// idleTimeout ends a connection that receives nothing for that long
+	idleTimeout time.Duration
+
+	// attempts and the connection state are guarded by mu, to be read by
+	// Status
+	attempts  int
+	connected time.Time
+	lastErr   error
+}
+
+func newConnection(url string) connection {
+	return connection{
+		events: make(chan SSEvent),
+		url:    url,
+		log:    stdLogger{}.With("source", url),
+		retry:  defaultRetry,
+	}
+}
+
+// SubscriptionStatus describes a subscription's connection to its source.
+type SubscriptionStatus struct {
+	Connected bool
+	// Since is when the current connection was made
+	Since time.Time `json:",omitempty"`
+	// Attempts counts the connections tried, including the current one
+	Attempts int
+	// LastError is why the previous connection ended or failed
+	LastError string `json:",omitempty"`
+}
+
+// String names the subscription in the supervisor's logs.
+func (c *connection) String() string {
+	return "subscription to " + c.url
+}
+
+// Events returns the channel the source's events are sent on.
+func (c *connection) Events() <-chan SSEvent {
+	return c.events
+}
+
+// Status returns the current state of the connection.
+func (c *connection) Status() SubscriptionStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := SubscriptionStatus{
+		Connected: !c.connected.IsZero(),
+		Since:     c.connected,
+		Attempts:  c.attempts,
+	}
+	if c.lastErr != nil {
+		status.LastError = c.lastErr.Error()
+	}
+	return status
+}
+
+func (c *connection) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func (c *connection) isStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// setConnected records that the connection was made.
+func (c *connection) setConnected() {
+	c.mu.Lock()
+	c.connected = time.Now()
+	c.mu.Unlock()
+	c.log.Infof("Connected to %s", c.url)
+	activeSubscriptions.WithLabelValues(c.url).Set(1)
+}
+
+// serve waits out the retry delay if reconnecting, then reads the source
+// until the connection ends, the context is done or the source is stopped.
+func (c *connection) serve(ctx context.Context, read func(ctx context.Context) error) (err error) {
+	if c.Status().Attempts > 0 {
+		delay, failures := c.reconnectDelay()
+		if failures > 1 {
+			c.log.Infof("Reconnecting to %s in %s after %d failures in a row", c.url, delay, failures)
+		} else {
+			c.log.Debugf("reconnecting to %s in %s", c.url, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return suture.ErrTerminateSupervisorTree
+	}
+	c.cancel = cancel
+	c.attempts++
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		connected := !c.connected.IsZero()
+		if connected && time.Since(c.connected) >= stableConnection {
+			c.failures = 0
+		} else if err != suture.ErrTerminateSupervisorTree && !errors.Is(err, context.Canceled) {
+			c.failures++
+		}
+		c.connected = time.Time{}
+		c.lastErr = err
+		c.mu.Unlock()
+
+		reason := "closed by the source"
+		switch {
+		case err == suture.ErrTerminateSupervisorTree || errors.Is(err, context.Canceled):
+			reason = "stopped"
+		case err != nil:
+			reason = err.Error()
+		}
+
+		if connected {
+			activeSubscriptions.WithLabelValues(c.url).Set(0)
+			c.log.Infof("Disconnected from %s: %s", c.url, reason)
+		} else if reason != "stopped" {
+			c.log.Warnf("Failed to connect to %s: %s", c.url, reason)
+		}
+	}()
+
+	err = read(ctx)
+
+	// the context is cancelled on Stop or shutdown, which also unblocks the
+	// read
+	if c.isStopped() {
+		return suture.ErrTerminateSupervisorTree
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// stableConnection is how long a connection must last for the reconnect
+// delay to be reset.
+const stableConnection = 30 * time.Second
+
+// reconnectDelay returns the delay before reconnecting, the retry delay
+// doubled for each consecutive failure after the first, up to the max, and
+// the number of failures.
+func (c *connection) reconnectDelay() (time.Duration, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.retry
+	for i := 1; i < c.failures && (c.maxRetry <= 0 || d < c.maxRetry); i++ {
+		d *= 2
+	}
+	if c.maxRetry > 0 && d > c.maxRetry {
+		d = c.maxRetry
+	}
+	return d, c.failures
+}
+
+// send passes an event on, remembering its id for reconnecting.
+func (c *connection) send(ctx context.Context, ev SSEvent) error {
+	select {
+	case c.events <- ev:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if ev.Id != "" {
+		c.lastEventID = ev.Id
+	}
+	return nil
+}
+
+// idleError is why a connection that received nothing for the timeout was
+// ended.
+func idleError(timeout time.Duration) error {
+	return fmt.Errorf("nothing received for %s", timeout)
+}
+
+// idleTimer calls cancel if it isn't reset within the timeout. A zero
+// timeout never fires.
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+func newIdleTimer(timeout time.Duration, cancel func()) *idleTimer {
+	t := &idleTimer{timeout: timeout}
+	if timeout > 0 {
+		t.timer = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&t.fired, 1)
+			cancel()
+		})
+	}
+	return t
+}
+
+func (t *idleTimer) reset() {
+	if t.timer != nil {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func (t *idleTimer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// expired reports whether the timer fired.
+func (t *idleTimer) expired() bool {
+	return atomic.LoadInt32(&t.fired) == 1
+}
+
+// err returns the idle error if the timer fired.
+func (t *idleTimer) err() error {
+	if t.expired() {
+		return idleError(t.timeout)
+	}
+	return nil
+}
+
+// websocketURL returns the url to dial for a websocket source, which may be
+// given with an http(s) scheme.
+func websocketURL(source string) string {
+	u, err := url.Parse(source)
+	if err != nil {
+		return source
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	return u.String()
+}
@@ -0,0 +1,237 @@
+package fwd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		maxRetry time.Duration
+		want     time.Duration
+	}{
+		{0, 0, time.Second},
+		{1, 0, time.Second},
+		{2, 0, 2 * time.Second},
+		{4, 0, 8 * time.Second},
+		{4, 5 * time.Second, 5 * time.Second},
+		{100, time.Minute, time.Minute},
+	}
+
+	for _, tt := range tests {
+		c := newConnection(testSource)
+		c.retry, c.maxRetry, c.failures = time.Second, tt.maxRetry, tt.failures
+		if got, _ := c.reconnectDelay(); got != tt.want {
+			t.Errorf("reconnectDelay() after %d failures with a max of %s = %s, want %s", tt.failures, tt.maxRetry, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionStatus(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+
+	log := &recordLogger{}
+	s := NewSubscription(srv.URL, 0)
+	s.log = log
+	if status := s.Status(); status.Connected || status.Attempts != 0 {
+		t.Errorf("status before serving = %+v", status)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- s.Serve(context.Background()) }()
+
+	deadline := time.Now().Add(time.Second)
+	for !s.Status().Connected && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status := s.Status(); !status.Connected || status.Since.IsZero() || status.Attempts != 1 {
+		t.Errorf("status while connected = %+v", status)
+	}
+
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatalf("Serve() = %v, want nil when the source closes the stream", err)
+	}
+	if status := s.Status(); status.Connected || status.LastError != "" {
+		t.Errorf("status after disconnecting = %+v", status)
+	}
+
+	want := []string{"info: Connected to " + srv.URL, "info: Disconnected from " + srv.URL + ": closed by the source"}
+	if lines := log.logged(); !equalStrings(lines, want) {
+		t.Errorf("logged %q, want %q", lines, want)
+	}
+}
+
+func TestSubscriptionStatusFailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	log := &recordLogger{}
+	s := NewSubscription(srv.URL, 0)
+	s.log = log
+	if err := s.Serve(context.Background()); err == nil {
+		t.Fatal("Serve() succeeded against a failing source")
+	}
+
+	status := s.Status()
+	if status.Connected || status.Attempts != 1 || !strings.Contains(status.LastError, "502") {
+		t.Errorf("status = %+v, want one failed attempt", status)
+	}
+	if lines := log.logged(); len(lines) != 1 || !strings.HasPrefix(lines[0], "warn: Failed to connect to "+srv.URL) {
+		t.Errorf("logged %q, want a failure to connect", lines)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepalive bool
+		err       string
+	}{
+		{"stalled stream", false, "nothing received for 50ms"},
+		{"keepalives", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.(http.Flusher).Flush()
+				for i := 0; i < 8; i++ {
+					select {
+					case <-time.After(20 * time.Millisecond):
+					case <-r.Context().Done():
+						return
+					}
+					if tt.keepalive {
+						w.Write([]byte(":\n"))
+						w.(http.Flusher).Flush()
+					}
+				}
+			}))
+			defer srv.Close()
+
+			s := NewSubscription(srv.URL, 0)
+			s.log = nopLogger{}
+			s.idleTimeout = 50 * time.Millisecond
+
+			err := s.read(context.Background())
+			if tt.err == "" && err != nil {
+				t.Errorf("read() = %v, want nil", err)
+			}
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("read() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIdleTimeoutStartsAfterHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("id: 1\ndata: {}\n\n"))
+	}))
+	defer srv.Close()
+
+	s := NewSubscription(srv.URL, 0)
+	s.idleTimeout = 50 * time.Millisecond
+	s.connectTimeout = time.Second
+
+	events, err := readEvents(t, s)
+	if err != nil || len(events) != 1 {
+		t.Errorf("read() = %d events, %v, want 1 event", len(events), err)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	c := newConnection(testSource)
+	c.log = nopLogger{}
+	c.retry, c.maxRetry = time.Millisecond, 4*time.Millisecond
+
+	fail := func(ctx context.Context) error { return errors.New("404 Not Found") }
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		c.serve(context.Background(), fail)
+		d, _ := c.reconnectDelay()
+		delays = append(delays, d)
+	}
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond}
+	if !reflect.DeepEqual(delays, want) {
+		t.Errorf("delays after failing = %s, want %s", delays, want)
+	}
+
+	// a connection that lasted long enough resets the backoff
+	healthy := func(ctx context.Context) error {
+		c.mu.Lock()
+		c.connected = time.Now().Add(-stableConnection)
+		c.mu.Unlock()
+		return nil
+	}
+	c.serve(context.Background(), healthy)
+	if d, failures := c.reconnectDelay(); d != time.Millisecond || failures != 0 {
+		t.Errorf("reconnectDelay() after a healthy connection = %s, %d, want %s, 0", d, failures, time.Millisecond)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		stall bool
+		err   string
+	}{
+		{"stalled connect", true, "within the connect timeout of 50ms"},
+		{"stream outlasting the timeout", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.stall {
+					<-r.Context().Done()
+					return
+				}
+				w.Header().Set("Content-Type", "text/event-stream")
+				for i := 0; i < 4; i++ {
+					w.Write([]byte("id: 1\ndata: {}\n\n"))
+					w.(http.Flusher).Flush()
+					time.Sleep(30 * time.Millisecond)
+				}
+			}))
+			defer srv.Close()
+
+			s := NewSubscription(srv.URL, 0)
+			s.connectTimeout = 50 * time.Millisecond
+
+			events, err := readEvents(t, s)
+			if tt.err == "" && (err != nil || len(events) != 4) {
+				t.Errorf("read() = %d events, %v, want all 4", len(events), err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("read() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestConnectTimeoutWebSocket(t *testing.T) {
+	f := newTestFwder(t, Route{Target: "http://localhost/", Protocol: protocolWebSocket, ConnectTimeout: Duration(time.Second)})
+	if ws := f.newSource().(*WebSocketSubscription); ws.dialer.HandshakeTimeout != time.Second {
+		t.Errorf("handshake timeout = %s, want the connect timeout", ws.dialer.HandshakeTimeout)
+	}
+}
@@ -0,0 +1,226 @@
+package fwd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultStoreSize is the number of events an EventStore keeps by default.
+const DefaultStoreSize = 1000
+
+// storedEvent is an event as received from a source, and whether it has been
+// handled since. In the file a change is a line holding the event when it is
+// received, and its source and id alone once it is delivered.
+type storedEvent struct {
+	Source    string
+	ID        string
+	Name      string `json:",omitempty"`
+	Data      []byte `json:",omitempty"`
+	Delivered bool   `json:",omitempty"`
+}
+
+// EventStore records received events on disk, so that events not yet
+// delivered when fwd stops are replayed when it starts again. It is a bounded
+// ring of the most recently received events, keyed by source and id. Changes
+// are appended to the file, which is compacted down to the ring once it holds
+// twice as many changes as the ring does events.
+type EventStore struct {
+	mu     sync.Mutex
+	path   string
+	size   int
+	events []storedEvent
+
+	// file is appended to, and lines counts the changes in it
+	file  *os.File
+	lines int
+}
+
+// OpenEventStore loads the store at path, starting an empty one if the file
+// doesn't exist yet.
+func OpenEventStore(path string, size int) (*EventStore, error) {
+	if size <= 0 {
+		size = DefaultStoreSize
+	}
+	s := &EventStore{path: path, size: size}
+
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = s.load(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load replays the changes in the file. A last line without a newline is the
+// remains of an interrupted write, and is ignored.
+func (s *EventStore) load(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var e storedEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		// a delivered line marks an event recorded earlier, unless it was
+		// written by compacting
+		if i := s.index(e.Source, e.ID); e.Delivered && i >= 0 {
+			s.events[i].Delivered = true
+			continue
+		}
+		s.add(nil, e)
+	}
+}
+
+// record adds a received event to the store as undelivered, evicting the
+// oldest event once the store is full.
+func (s *EventStore) record(log Logger, source string, ev SSEvent) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := storedEvent{Source: source, ID: ev.Id, Name: ev.Name, Data: ev.Data}
+	s.add(log, e)
+	s.append(log, e)
+}
+
+func (s *EventStore) add(log Logger, e storedEvent) {
+	if i := s.index(e.Source, e.ID); i >= 0 {
+		s.events = append(s.events[:i], s.events[i+1:]...)
+	}
+	s.events = append(s.events, e)
+
+	for len(s.events) > s.size {
+		if old := s.events[0]; !old.Delivered && log != nil {
+			log.Warnf("Event store is full, evicting undelivered event %s from %s", old.ID, old.Source)
+		}
+		s.events = s.events[1:]
+	}
+}
+
+// delivered marks the event as handled so it isn't replayed.
+func (s *EventStore) delivered(log Logger, source, id string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.index(source, id); i >= 0 && !s.events[i].Delivered {
+		s.events[i].Delivered = true
+		s.append(log, storedEvent{Source: source, ID: id, Delivered: true})
+	}
+}
+
+// pending returns the source's undelivered events, oldest first.
+func (s *EventStore) pending(source string) []SSEvent {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []SSEvent
+	for _, e := range s.events {
+		if e.Source == source && !e.Delivered {
+			events = append(events, SSEvent{Id: e.ID, Name: e.Name, Data: e.Data})
+		}
+	}
+	return events
+}
+
+func (s *EventStore) index(source, id string) int {
+	for i, e := range s.events {
+		if e.Source == source && e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// append writes a change to the end of the file, then compacts the file if
+// it has grown to twice the size of the ring.
+func (s *EventStore) append(log Logger, e storedEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("error encoding event store: %s", err)
+		return
+	}
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		log.Errorf("error writing event store: %s", err)
+		return
+	}
+	s.lines++
+
+	if s.lines >= 2*s.size {
+		if err := s.compact(); err != nil {
+			log.Errorf("error compacting event store: %s", err)
+		}
+	}
+}
+
+// compact rewrites the file as the events in the ring, to a temporary file
+// that is renamed into place so a crash mid-write leaves the previous version
+// intact, and reopens it for appending.
+func (s *EventStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range s.events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	s.lines = len(s.events)
+	return nil
+}
@@ -0,0 +1,121 @@
+package fwd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestStore opens a store in a new directory, returning its path.
+func openTestStore(t *testing.T, size int) (*EventStore, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "events.jsonl")
+	s, err := OpenEventStore(path, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func pendingIDs(s *EventStore, source string) []string {
+	var ids []string
+	for _, ev := range s.pending(source) {
+		ids = append(ids, ev.Id)
+	}
+	return ids
+}
+
+func TestEventStoreReplay(t *testing.T) {
+	s, path := openTestStore(t, 10)
+	for _, id := range []string{"1", "2", "3"} {
+		s.record(nopLogger{}, testSource, SSEvent{Id: id, Data: []byte(`{"n":` + id + `}`)})
+	}
+	s.record(nopLogger{}, "https://smee.io/other", SSEvent{Id: "1"})
+	s.delivered(nopLogger{}, testSource, "2")
+
+	reopened, err := OpenEventStore(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := pendingIDs(reopened, testSource); !equalStrings(ids, []string{"1", "3"}) {
+		t.Errorf("pending after reopening = %v, want [1 3]", ids)
+	}
+	if ev := reopened.pending(testSource)[1]; string(ev.Data) != `{"n":3}` {
+		t.Errorf("replayed data = %s, want the recorded data", ev.Data)
+	}
+	if ids := pendingIDs(reopened, "https://smee.io/other"); !equalStrings(ids, []string{"1"}) {
+		t.Errorf("pending of another source = %v, want [1]", ids)
+	}
+}
+
+func TestEventStoreEvicts(t *testing.T) {
+	s, _ := openTestStore(t, 2)
+	for _, id := range []string{"1", "2", "3"} {
+		s.record(nopLogger{}, testSource, SSEvent{Id: id})
+	}
+	if ids := pendingIDs(s, testSource); !equalStrings(ids, []string{"2", "3"}) {
+		t.Errorf("pending = %v, want the 2 most recent", ids)
+	}
+}
+
+func TestEventStoreCompacts(t *testing.T) {
+	s, path := openTestStore(t, 2)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		s.record(nopLogger{}, testSource, SSEvent{Id: id})
+		s.delivered(nopLogger{}, testSource, id)
+	}
+	s.record(nopLogger{}, testSource, SSEvent{Id: "5"})
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n >= 4 {
+		t.Errorf("store has %d lines, want it compacted below twice its size:\n%s", n, b)
+	}
+
+	reopened, err := OpenEventStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := pendingIDs(reopened, testSource); !equalStrings(ids, []string{"5"}) {
+		t.Errorf("pending after compacting = %v, want [5]", ids)
+	}
+}
+
+func TestEventStoreTruncatedLine(t *testing.T) {
+	s, path := openTestStore(t, 10)
+	s.record(nopLogger{}, testSource, SSEvent{Id: "1"})
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Source":"https://smee.io/test","ID":"2","Da`)
+	f.Close()
+
+	reopened, err := OpenEventStore(path, 10)
+	if err != nil {
+		t.Fatalf("OpenEventStore with a truncated last line: %s", err)
+	}
+	if ids := pendingIDs(reopened, testSource); !equalStrings(ids, []string{"1"}) {
+		t.Errorf("pending = %v, want [1]", ids)
+	}
+}
+
+func TestEventStoreNil(t *testing.T) {
+	var s *EventStore
+	s.record(nopLogger{}, testSource, SSEvent{Id: "1"})
+	s.delivered(nopLogger{}, testSource, "1")
+	if events := s.pending(testSource); events != nil {
+		t.Errorf("pending of a nil store = %v", events)
+	}
+}
@@ -0,0 +1,211 @@
+package fwd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type SSEvent struct {
+	Id   string
+	Name string
+	Data []byte
+}
+
+func (ev SSEvent) Format() string {
+	return fmt.Sprintf("id=%v, name=%v, payload=%v", ev.Id, ev.Name, string(ev.Data))
+}
+
+const (
+	// DefaultMaxEventSize is the default limit on the size of a single SSE
+	// line.
+	DefaultMaxEventSize = 512 * 1024
+
+	// defaultRetry is the reconnect delay used until an SSE server sends one.
+	defaultRetry = 3 * time.Second
+)
+
+// Subscription reads a source's events over SSE.
+type Subscription struct {
+	connection
+	client *http.Client
+
+	// connectTimeout bounds waiting for the response headers, after which
+	// the stream may run for as long as it likes
+	connectTimeout time.Duration
+
+	// maxEventSize bounds the scanner buffer, and so the largest event
+	maxEventSize int
+}
+
+func NewSubscription(url string, maxEventSize int) *Subscription {
+	if maxEventSize <= 0 {
+		maxEventSize = DefaultMaxEventSize
+	}
+	return &Subscription{
+		connection:   newConnection(url),
+		client:       &http.Client{},
+		maxEventSize: maxEventSize,
+	}
+}
+
+func (s *Subscription) Serve(ctx context.Context) error {
+	return s.serve(ctx, s.read)
+}
+
+// read connects to the source and sends on its events until the stream ends.
+func (s *Subscription) read(ctx context.Context) error {
+	// the timers cancel only this connection, so that it is retried
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	for k, v := range s.header {
+		req.Header.Set(k, v)
+	}
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+	connect := newIdleTimer(s.connectTimeout, cancel)
+	resp, err := s.client.Do(req)
+	connect.stop()
+	if connect.expired() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return fmt.Errorf("no response from %s within the connect timeout of %s", s.url, s.connectTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// waiting for the headers is bounded by the connect timeout alone
+	idle := newIdleTimer(s.idleTimeout, cancel)
+	defer idle.stop()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Error: resp.StatusCode == %d\n", resp.StatusCode)
+	}
+
+	// parameters such as charset=utf-8 are allowed
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		return fmt.Errorf("Error: invalid Content-Type == %s\n", resp.Header.Get("Content-Type"))
+	}
+
+	s.setConnected()
+
+	var buf bytes.Buffer
+	ev := SSEvent{}
+	scanner := bufio.NewScanner(resp.Body)
+	// the max is only applied if it is at least the buffer's capacity
+	size := 64 * 1024
+	if s.maxEventSize < size {
+		size = s.maxEventSize
+	}
+	scanner.Buffer(make([]byte, 0, size), s.maxEventSize)
+	for scanner.Scan() {
+		// waiting for the fwder to take an event doesn't count as idle
+		idle.stop()
+		if err := s.parseSend(ctx, scanner.Bytes(), &buf, &ev); err != nil {
+			return err
+		}
+		idle.reset()
+	}
+
+	if err := idle.err(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			s.log.Errorf("event from %s exceeded the max event size of %d bytes and was lost, raise it with -max-event-size or max_event_size", s.url, s.maxEventSize)
+		} else {
+			s.log.Warnf("%s: scanner.Text(): %s", err, scanner.Text())
+		}
+		return fmt.Errorf("error during resp.Body read: %w", err)
+	}
+
+	return nil
+}
+
+// parseSend will build the event and when complete send and reset the buffer
+func (s *Subscription) parseSend(ctx context.Context, line []byte, buf *bytes.Buffer, ev *SSEvent) error {
+	s.log.Debugf("len: %d line: %s", len(line), string(line))
+
+	// end of event
+	if len(line) == 0 {
+		// as per the SSE spec an event without data, such as the blank line
+		// after a comment, isn't dispatched, though its id is still kept
+		if buf.Len() == 0 {
+			if ev.Id != "" {
+				s.lastEventID = ev.Id
+			}
+			*ev = SSEvent{}
+			return nil
+		}
+
+		// copy as buf is reused for the next event
+		ev.Data = append([]byte(nil), bytes.TrimSuffix(buf.Bytes(), []byte("\n"))...)
+		buf.Reset()
+		err := s.send(ctx, *ev)
+		*ev = SSEvent{}
+		return err
+	}
+
+	// comment, sent by smee.io and others as a keepalive
+	if line[0] == ':' {
+		return nil
+	}
+
+	field, value := parseField(line)
+	switch string(field) {
+
+	// start of event
+	case "id":
+		ev.Id = string(value)
+
+	// event name
+	case "event":
+		ev.Name = string(value)
+
+	// reconnection time in milliseconds, ignored if malformed
+	case "retry":
+		ms, err := strconv.Atoi(string(value))
+		if err != nil || ms < 0 {
+			s.log.Debugf("ignoring invalid retry: %s", string(value))
+			break
+		}
+		s.retry = time.Duration(ms) * time.Millisecond
+
+	// event data, with multiple data lines joined by newlines
+	case "data":
+		buf.Write(value)
+		buf.WriteByte('\n')
+
+	default:
+		return fmt.Errorf("error during EventReadLoop - Default triggered! len:%d\n%s", len(line), line)
+	}
+
+	return nil
+}
+
+// parseField splits a line into its field name and value. As per the SSE spec
+// a single space after the colon is not part of the value, and a line without
+// a colon is a field with an empty value.
+func parseField(line []byte) (field, value []byte) {
+	i := bytes.IndexByte(line, ':')
+	if i < 0 {
+		return line, nil
+	}
+	return line[:i], bytes.TrimPrefix(line[i+1:], []byte(" "))
+}
@@ -0,0 +1,363 @@
+package fwd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSSEServer returns a server that writes the stream as an SSE response to
+// each request.
+func newSSEServer(t *testing.T, stream string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// readEvents reads the subscription's source once, to the end of the
+// stream, returning the events received.
+func readEvents(t *testing.T, s *Subscription) ([]SSEvent, error) {
+	t.Helper()
+	s.log = nopLogger{}
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		err = s.read(context.Background())
+		close(done)
+	}()
+
+	var events []SSEvent
+	for {
+		select {
+		case ev := <-s.Events():
+			events = append(events, ev)
+		case <-done:
+			return events, err
+		}
+	}
+}
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		line  string
+		field string
+		value string
+	}{
+		{"data: x", "data", "x"},
+		{"data:x", "data", "x"},
+		{"data:  x", "data", " x"},
+		{"data:", "data", ""},
+		{"data", "data", ""},
+		{"id: a:b", "id", "a:b"},
+	}
+
+	for _, tt := range tests {
+		field, value := parseField([]byte(tt.line))
+		if string(field) != tt.field || string(value) != tt.value {
+			t.Errorf("parseField(%q) = %q, %q, want %q, %q", tt.line, field, value, tt.field, tt.value)
+		}
+	}
+}
+
+func TestSubscriptionParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []SSEvent
+	}{
+		{"space after colon", "id: 1\nevent: push\ndata: {}\n\n", []SSEvent{{Id: "1", Name: "push", Data: []byte("{}")}}},
+		{"no space after colon", "id:1\nevent:push\ndata:{}\n\n", []SSEvent{{Id: "1", Name: "push", Data: []byte("{}")}}},
+		{"two events", "id: 1\ndata: a\n\nid: 2\ndata: b\n\n", []SSEvent{{Id: "1", Data: []byte("a")}, {Id: "2", Data: []byte("b")}}},
+		{"incomplete event", "id: 1\ndata: a\n", nil},
+		{"comments", ":ok\n\n: ping\n\nid: 1\n:ping\ndata: a\n\n", []SSEvent{{Id: "1", Data: []byte("a")}}},
+		{"blank line after a comment", ":ping\n\n", nil},
+		{"multi-line data", "id: 1\ndata: {\ndata: \"a\": 1\ndata: }\n\n", []SSEvent{{Id: "1", Data: []byte("{\n\"a\": 1\n}")}}},
+		{"empty data line", "id: 1\ndata: a\ndata:\ndata: b\n\n", []SSEvent{{Id: "1", Data: []byte("a\n\nb")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSSEServer(t, tt.stream)
+			events, err := readEvents(t, NewSubscription(srv.URL, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(events, tt.want) {
+				t.Errorf("events = %+v, want %+v", events, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionUnknownField(t *testing.T) {
+	srv := newSSEServer(t, "nope: 1\n\n")
+	if _, err := readEvents(t, NewSubscription(srv.URL, 0)); err == nil {
+		t.Error("read() succeeded, want an error for the unknown field")
+	}
+}
+
+func TestSubscriptionLastEventID(t *testing.T) {
+	ids := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids <- r.Header.Get("Last-Event-ID")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("id: 1\ndata: a\n\nid: 2\ndata: b\n\n"))
+	}))
+	defer srv.Close()
+
+	s := NewSubscription(srv.URL, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := readEvents(t, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if first, second := <-ids, <-ids; first != "" || second != "2" {
+		t.Errorf("Last-Event-ID = %q then %q, want none then 2", first, second)
+	}
+}
+
+func TestSubscriptionKeepsIDWithoutData(t *testing.T) {
+	srv := newSSEServer(t, "id: 1\ndata: a\n\nid: 2\n\n")
+	s := NewSubscription(srv.URL, 0)
+	if _, err := readEvents(t, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.lastEventID != "2" {
+		t.Errorf("lastEventID = %q, want 2", s.lastEventID)
+	}
+}
+
+func TestSubscriptionRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   time.Duration
+	}{
+		{"set", "retry: 250\n\n", 250 * time.Millisecond},
+		{"malformed", "retry: soon\n\n", defaultRetry},
+		{"negative", "retry: -1\n\n", defaultRetry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSSEServer(t, tt.stream)
+			s := NewSubscription(srv.URL, 0)
+			if _, err := readEvents(t, s); err != nil {
+				t.Fatal(err)
+			}
+			if s.retry != tt.want {
+				t.Errorf("retry = %s, want %s", s.retry, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionCancel(t *testing.T) {
+	// the stream is held open until the test ends
+	release := make(chan struct{})
+	defer close(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	s := NewSubscription(srv.URL, 0)
+	s.log = nopLogger{}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.read(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("read() = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read() didn't return when the context was cancelled")
+	}
+}
+
+func TestSubscriptionContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		ok          bool
+	}{
+		{"text/event-stream", true},
+		{"text/event-stream; charset=utf-8", true},
+		{"Text/Event-Stream;charset=UTF-8", true},
+		{"application/json", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte("id: 1\ndata: a\n\n"))
+			}))
+			defer srv.Close()
+
+			events, err := readEvents(t, NewSubscription(srv.URL, 0))
+			if tt.ok && (err != nil || len(events) != 1) {
+				t.Errorf("read() = %v, %v, want one event", events, err)
+			}
+			if !tt.ok && (err == nil || len(events) != 0) {
+				t.Errorf("read() = %v, %v, want an invalid Content-Type error", events, err)
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// closeTracker is a response body that counts its closes.
+type closeTracker struct {
+	io.Reader
+	closes *int32
+}
+
+func (c closeTracker) Close() error {
+	atomic.AddInt32(c.closes, 1)
+	return nil
+}
+
+func TestSubscriptionClosesRejectedResponses(t *testing.T) {
+	responses := []struct {
+		status      int
+		contentType string
+	}{
+		{http.StatusBadGateway, "text/html"},
+		{http.StatusServiceUnavailable, "text/event-stream"},
+		{http.StatusOK, "application/json"},
+	}
+
+	var closes int32
+	s := NewSubscription("http://smee.test/abc", 0)
+	for i, r := range responses {
+		s.client = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: r.status,
+				Header:     http.Header{"Content-Type": {r.contentType}},
+				Body:       closeTracker{Reader: strings.NewReader("id: 1\ndata: a\n\n"), closes: &closes},
+				Request:    req,
+			}, nil
+		})}
+		if _, err := readEvents(t, s); err == nil {
+			t.Fatalf("read() of %+v succeeded", r)
+		}
+		if n := atomic.LoadInt32(&closes); n != int32(i+1) {
+			t.Fatalf("%d of %d response bodies closed", n, i+1)
+		}
+	}
+}
+
+func TestSubscriptionHeaders(t *testing.T) {
+	header := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header <- r.Header
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	}))
+	defer srv.Close()
+
+	f, err := NewFwder(srv.URL, Route{
+		Target:        "http://localhost/",
+		SourceHeaders: map[string]string{"Authorization": "Bearer abc", "Accept": "text/event-stream, application/json"},
+	}, WithLogger(nopLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEvents(t, f.newSource().(*Subscription)); err != nil {
+		t.Fatal(err)
+	}
+
+	h := <-header
+	if got := h.Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("Authorization = %q, want Bearer abc", got)
+	}
+	if got := h.Values("Accept"); len(got) != 1 || got[0] != "text/event-stream, application/json" {
+		t.Errorf("Accept = %q, want the configured Accept in place of the default", got)
+	}
+}
+
+func TestSubscriptionMaxEventSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		err  bool
+	}{
+		{"at the max", 100, false},
+		{"over the max", 101, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the line is counted with its newline
+			data := "data: " + strings.Repeat("a", tt.size-len("data: \n"))
+			srv := newSSEServer(t, "id: 1\n"+data+"\n\n")
+
+			log := &recordLogger{}
+			s := NewSubscription(srv.URL, 100)
+			s.log = log
+			done := make(chan error, 1)
+			go func() { done <- s.read(context.Background()) }()
+
+			var events []SSEvent
+			var err error
+		read:
+			for {
+				select {
+				case ev := <-s.Events():
+					events = append(events, ev)
+				case err = <-done:
+					break read
+				}
+			}
+
+			if !tt.err && (err != nil || len(events) != 1) {
+				t.Errorf("read() = %d events, %v, want the event", len(events), err)
+			}
+			if tt.err && (!errors.Is(err, bufio.ErrTooLong) || len(events) != 0) {
+				t.Errorf("read() = %d events, %v, want %v", len(events), err, bufio.ErrTooLong)
+			}
+			want := "error: event from " + srv.URL + " exceeded the max event size of 100 bytes and was lost, raise it with -max-event-size or max_event_size"
+			if logged := containsString(log.logged(), want); logged != tt.err {
+				t.Errorf("logged %q, want the max event size error logged: %v", log.logged(), tt.err)
+			}
+		})
+	}
+}
+
+func TestMaxEventSizeFromRoute(t *testing.T) {
+	f := newTestFwder(t, Route{Target: "http://localhost/", MaxEventSize: 1024})
+	if s := f.newSource().(*Subscription); s.maxEventSize != 1024 {
+		t.Errorf("max event size = %d, want the route's 1024", s.maxEventSize)
+	}
+	if s := NewSubscription(testSource, 0); s.maxEventSize != DefaultMaxEventSize {
+		t.Errorf("max event size = %d, want the default %d", s.maxEventSize, DefaultMaxEventSize)
+	}
+}
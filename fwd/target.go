@@ -0,0 +1,81 @@
+package fwd
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// queryValues is the query string of the original request as relayed in the
+// payload, where each parameter is either a single string or a list of them.
+type queryValues url.Values
+
+func (q *queryValues) UnmarshalJSON(b []byte) error {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(b, &params); err != nil {
+		return err
+	}
+
+	values := make(queryValues, len(params))
+	for k, raw := range params {
+		var v string
+		if err := json.Unmarshal(raw, &v); err == nil {
+			values[k] = []string{v}
+			continue
+		}
+
+		var vs []string
+		if err := json.Unmarshal(raw, &vs); err != nil {
+			return err
+		}
+		values[k] = vs
+	}
+	*q = values
+	return nil
+}
+
+// query returns the query string of the original request, from the payload's
+// query or else the query string of a path relayed with one.
+func (p Payload) query() queryValues {
+	if len(p.Query) > 0 || p.Path == "" {
+		return p.Query
+	}
+	u, err := url.Parse(p.Path)
+	if err != nil {
+		return nil
+	}
+	return queryValues(u.Query())
+}
+
+// targetURL appends the path to the target's path and merges the query into
+// the target's query string. Where both set a parameter the values of both
+// are sent, the target's first.
+func targetURL(target, path string, query queryValues) (string, error) {
+	// exec and sink targets have no path to add to
+	if scheme, _ := splitTarget(target); scheme != "" {
+		return target, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	if path != "" {
+		p, err := url.Parse(path)
+		if err != nil {
+			return "", err
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(p.Path, "/")
+		u.RawPath = ""
+	}
+
+	if len(query) > 0 {
+		q := u.Query()
+		for k, vs := range query {
+			q[k] = append(q[k], vs...)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String(), nil
+}
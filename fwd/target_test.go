@@ -0,0 +1,118 @@
+package fwd
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+func TestTargetURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		path   string
+		query  queryValues
+		want   string
+	}{
+		{"nothing to add", "http://localhost:3000/hook", "", nil, "http://localhost:3000/hook"},
+		{"path", "http://localhost:3000/hooks/", "/github", nil, "http://localhost:3000/hooks/github"},
+		{"path onto root", "http://localhost:3000", "/github", nil, "http://localhost:3000/github"},
+		{"path with query", "http://localhost:3000", "/github?ignored=1", nil, "http://localhost:3000/github"},
+		{"query", "http://localhost:3000/hook", "", queryValues{"a": {"1"}}, "http://localhost:3000/hook?a=1"},
+		{"query merged, target's first", "http://localhost:3000/hook?a=0&b=2", "", queryValues{"a": {"1", "3"}}, "http://localhost:3000/hook?a=0&a=1&a=3&b=2"},
+		{"escaped path", "http://localhost:3000/", "/a%20b", nil, "http://localhost:3000/a%20b"},
+		{"exec target", "exec:./handle.sh", "/github", queryValues{"a": {"1"}}, "exec:./handle.sh"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := targetURL(tt.target, tt.path, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("targetURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPayloadQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		want    url.Values
+	}{
+		{"from the query", Payload{Path: "/hook?a=0", Query: queryValues{"a": {"1"}}}, url.Values{"a": {"1"}}},
+		{"from the path", Payload{Path: "/hook?a=1&a=2"}, url.Values{"a": {"1", "2"}}},
+		{"none", Payload{}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := url.Values(tt.payload.query()); got.Encode() != tt.want.Encode() {
+			t.Errorf("%s: query() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestForwardPath(t *testing.T) {
+	target := newTestTarget(t)
+	f := newTestFwder(t, Route{Target: target.URL + "/base?key=k", ForwardPath: true})
+
+	ev := SSEvent{Id: "1", Data: []byte(`{"path": "/webhooks/github", "query": {"a": "1", "b": ["2", "3"]}, "body": {}}`)}
+	if _, err := f.ForwardEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := target.received()[0].URI, "/base/webhooks/github?a=1&b=2&b=3&key=k"; got != want {
+		t.Errorf("target received %s, want %s", got, want)
+	}
+}
+
+func TestQueryValuesJSON(t *testing.T) {
+	var q queryValues
+	if err := json.Unmarshal([]byte(`{"a": "1", "b": ["2", "3"], "c": []}`), &q); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := url.Values(q).Encode(), "a=1&b=2&b=3"; got != want {
+		t.Errorf("query = %s, want %s", got, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"a": 1}`), &q); err == nil {
+		t.Error("Unmarshal() of a number succeeded, want an error")
+	}
+}
+
+func TestForwardQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		uri  string
+		body string
+		ct   string
+	}{
+		{"single values", `{"path": "/ignored", "query": {"a": "1"}, "body": {"x": 1}}`, "/hook?a=1&key=k", `{"x": 1}`, ""},
+		{"multiple values", `{"query": {"a": ["1", "2"], "key": "q"}, "body": {}}`, "/hook?a=1&a=2&key=k&key=q", `{}`, ""},
+		{"params as the body", `{"query": {"a": "1"}, "params": {"b": ["2", "3"]}}`, "/hook?a=1&key=k", "b=2&b=3", "application/x-www-form-urlencoded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			f := newTestFwder(t, Route{Target: target.URL + "/hook?key=k", ForwardQuery: true})
+
+			if _, err := f.ForwardEvent(SSEvent{Id: "1", Data: []byte(tt.data)}); err != nil {
+				t.Fatal(err)
+			}
+			got := target.received()
+			if len(got) != 1 {
+				t.Fatalf("target received %d requests, want 1", len(got))
+			}
+			if got[0].URI != tt.uri || got[0].Body != tt.body {
+				t.Errorf("target received %s %s, want %s %s", got[0].URI, got[0].Body, tt.uri, tt.body)
+			}
+			if tt.ct != "" && got[0].Header.Get("Content-Type") != tt.ct {
+				t.Errorf("Content-Type = %q, want %q", got[0].Header.Get("Content-Type"), tt.ct)
+			}
+		})
+	}
+}
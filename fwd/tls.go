@@ -0,0 +1,48 @@
+package fwd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsConfig builds the TLS config for connecting to the route's targets, or
+// returns nil to use the defaults when none of the TLS options are set.
+func (r Route) tlsConfig() (*tls.Config, error) {
+	if r.CAFile == "" && r.CertFile == "" && r.KeyFile == "" && !r.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: r.InsecureSkipVerify}
+
+	if r.CAFile != "" {
+		pem, err := ioutil.ReadFile(r.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", r.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if r.CertFile != "" || r.KeyFile != "" {
+		if r.CertFile == "" || r.KeyFile == "" {
+			return nil, errors.New("cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
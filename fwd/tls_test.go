@@ -0,0 +1,120 @@
+package fwd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePEM writes the block to a file in dir, returning its path.
+func writePEM(t *testing.T, dir, name, blockType string, b []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	ca := writePEM(t, dir, "ca.pem", "CERTIFICATE", target.Certificate().Raw)
+
+	tests := []struct {
+		name  string
+		route Route
+		ok    bool
+	}{
+		{"untrusted", Route{}, false},
+		{"ca file", Route{CAFile: ca}, true},
+		{"insecure skip verify", Route{InsecureSkipVerify: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.Target = target.URL
+			f := newTestFwder(t, tt.route)
+
+			_, err := f.ForwardEvent(smeeEvent("1", nil, `{}`))
+			if ok := err == nil; ok != tt.ok {
+				t.Errorf("ForwardEvent() error = %v, want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTLSClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var peerCerts int
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerCerts = len(r.TLS.PeerCertificates)
+	}))
+	target.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	target.StartTLS()
+	defer target.Close()
+
+	// the server's own certificate will do as the client's
+	cert := target.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writePEM(t, dir, "cert.pem", "CERTIFICATE", cert.Certificate[0])
+	keyFile := writePEM(t, dir, "key.pem", "PRIVATE KEY", key)
+
+	f := newTestFwder(t, Route{Target: target.URL, InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile})
+	if _, err := f.ForwardEvent(smeeEvent("1", nil, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if peerCerts != 1 {
+		t.Errorf("target saw %d client certificates, want 1", peerCerts)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		route Route
+		err   string
+	}{
+		{"no certificates", Route{CAFile: empty}, "no certificates found"},
+		{"cert without key", Route{CertFile: empty}, "must be set together"},
+		{"missing ca file", Route{CAFile: filepath.Join(dir, "missing.pem")}, "no such file"},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.route.tlsConfig(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: tlsConfig() error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package fwd
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+)
+
+// transformFuncs are available to body transform templates, in addition to
+// the text/template builtins.
+var transformFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// transformData is what a body transform template is executed with.
+type transformData struct {
+	// Body is the decoded JSON body, or nil if it isn't JSON
+	Body interface{}
+	// Raw is the original body as text
+	Raw     string
+	Event   string
+	Headers map[string]string
+}
+
+func parseTransform(text string) (*template.Template, error) {
+	return template.New("transform").Funcs(transformFuncs).Option("missingkey=error").Parse(text)
+}
+
+// transformBody rewrites the body with the route's transform template,
+// decoding it first if it has a content-encoding.
+func (f *Fwder) transformBody(p Payload, event string) ([]byte, error) {
+	raw, err := decodeBody(p.ContentEncoding, p.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	data := transformData{
+		Raw:     string(raw),
+		Event:   event,
+		Headers: p.Headers,
+	}
+	json.Unmarshal(raw, &data.Body)
+
+	var buf bytes.Buffer
+	if err := f.transform.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,36 @@
+package fwd
+
+import "testing"
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform string
+		body      string
+		want      string
+	}{
+		{"hoist a field", `{"repo":{{json .Body.repository.name}},"event":{{json .Event}}}`, `{"repository":{"name":"fwd"}}`, `{"repo":"fwd","event":"push"}`},
+		{"original body", `{"original":{{.Raw}}}`, `{"a":1}`, `{"original":{"a":1}}`},
+		{"headers", `{{index .Headers "x-github-event"}}`, `{}`, `push`},
+		{"missing key forwards the original", `{{.Body.missing.name}}`, `{"a":1}`, `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTestTarget(t)
+			f := newTestFwder(t, Route{Target: target.URL, Transform: tt.transform})
+			if _, err := f.ForwardEvent(smeeEvent("1", map[string]string{"x-github-event": "push"}, tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if got := target.received()[0].Body; got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformInvalid(t *testing.T) {
+	if _, err := NewFwder(testSource, Route{Target: "http://localhost/", Transform: "{{.Body"}); err == nil {
+		t.Error("NewFwder() with an invalid transform succeeded")
+	}
+}
@@ -0,0 +1,140 @@
+package fwd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"time"
+)
+
+// WebSocketSubscription reads a source's events from a websocket, one event
+// per message.
+type WebSocketSubscription struct {
+	connection
+	dialer websocket.Dialer
+
+	// maxEventSize bounds the size of a message, and so the largest event
+	maxEventSize int
+}
+
+func NewWebSocketSubscription(url string, maxEventSize int) *WebSocketSubscription {
+	if maxEventSize <= 0 {
+		maxEventSize = DefaultMaxEventSize
+	}
+	return &WebSocketSubscription{
+		connection:   newConnection(url),
+		dialer:       *websocket.DefaultDialer,
+		maxEventSize: maxEventSize,
+	}
+}
+
+func (w *WebSocketSubscription) Serve(ctx context.Context) error {
+	return w.serve(ctx, w.read)
+}
+
+// read dials the source and sends on the events in its messages until the
+// connection closes.
+func (w *WebSocketSubscription) read(ctx context.Context) error {
+	header := http.Header{}
+	for k, v := range w.header {
+		header.Set(k, v)
+	}
+	if w.lastEventID != "" {
+		header.Set("Last-Event-ID", w.lastEventID)
+	}
+	conn, resp, err := w.dialer.DialContext(ctx, websocketURL(w.url), header)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return fmt.Errorf("%w: status %s", err, resp.Status)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	// closing the connection unblocks the read when the context is done
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(int64(w.maxEventSize))
+	conn.SetPingHandler(func(data string) error {
+		w.extendDeadline(conn)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	w.setConnected()
+
+	for {
+		w.extendDeadline(conn)
+		_, msg, err := conn.ReadMessage()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return idleError(w.idleTimeout)
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			return nil
+		}
+		if errors.Is(err, websocket.ErrReadLimit) {
+			w.log.Errorf("message from %s exceeded the max event size of %d bytes and was lost, raise it with -max-event-size or max_event_size", w.url, w.maxEventSize)
+		}
+		if err != nil {
+			return err
+		}
+
+		ev, err := parseMessage(msg)
+		if err != nil {
+			w.log.Warnf("Skipping malformed message from %s: %s", w.url, err)
+			continue
+		}
+		if err := w.send(ctx, ev); err != nil {
+			return err
+		}
+	}
+}
+
+// extendDeadline gives the connection another idle timeout to receive
+// something.
+func (w *WebSocketSubscription) extendDeadline(conn *websocket.Conn) {
+	if w.idleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(w.idleTimeout))
+	}
+}
+
+// message is an event sent over a websocket. Data is the payload, as a JSON
+// object or a string holding one. A message without data is itself the
+// payload.
+type message struct {
+	Id    json.RawMessage `json:"id"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// parseMessage returns the event in a websocket message. The id may be a
+// string or a number.
+func parseMessage(b []byte) (SSEvent, error) {
+	var m message
+	if err := json.Unmarshal(b, &m); err != nil {
+		return SSEvent{}, err
+	}
+
+	ev := SSEvent{Name: m.Event, Data: m.Data}
+	if len(m.Id) > 0 && !bytes.Equal(m.Id, []byte("null")) {
+		if err := json.Unmarshal(m.Id, &ev.Id); err != nil {
+			ev.Id = string(m.Id)
+		}
+	}
+
+	var s string
+	switch {
+	case len(m.Data) == 0:
+		ev.Data = b
+	case json.Unmarshal(m.Data, &s) == nil:
+		ev.Data = []byte(s)
+	}
+	return ev, nil
+}
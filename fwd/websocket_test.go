@@ -0,0 +1,146 @@
+package fwd
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want SSEvent
+	}{
+		{"object data", `{"id":"1","event":"push","data":{"body":{}}}`, SSEvent{Id: "1", Name: "push", Data: []byte(`{"body":{}}`)}},
+		{"string data", `{"id":"2","data":"{\"body\":{}}"}`, SSEvent{Id: "2", Data: []byte(`{"body":{}}`)}},
+		{"numeric id", `{"id":3,"data":{}}`, SSEvent{Id: "3", Data: []byte(`{}`)}},
+		{"null id", `{"id":null,"data":{}}`, SSEvent{Data: []byte(`{}`)}},
+		{"message is the payload", `{"body":{"a":1}}`, SSEvent{Data: []byte(`{"body":{"a":1}}`)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := parseMessage([]byte(tt.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Id != tt.want.Id || ev.Name != tt.want.Name || string(ev.Data) != string(tt.want.Data) {
+				t.Errorf("parseMessage() = %+v, want %+v", ev, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseMessage([]byte("not json")); err == nil {
+		t.Error("parseMessage() of a malformed message succeeded")
+	}
+}
+
+func TestWebSocketURL(t *testing.T) {
+	for source, want := range map[string]string{
+		"http://localhost/events": "ws://localhost/events",
+		"https://relay.io/abc":    "wss://relay.io/abc",
+		"wss://relay.io/abc?k=v":  "wss://relay.io/abc?k=v",
+	} {
+		if got := websocketURL(source); got != want {
+			t.Errorf("websocketURL(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	tests := []struct {
+		source   string
+		route    Route
+		protocol string
+	}{
+		{"https://smee.io/abc", Route{}, protocolSSE},
+		{"wss://relay.io/abc", Route{}, protocolWebSocket},
+		{"https://relay.io/abc", Route{Protocol: protocolWebSocket}, protocolWebSocket},
+	}
+
+	for _, tt := range tests {
+		if got := tt.route.protocol(tt.source); got != tt.protocol {
+			t.Errorf("protocol of %s = %q, want %q", tt.source, got, tt.protocol)
+		}
+	}
+}
+
+func TestWebSocketSubscription(t *testing.T) {
+	var lastEventID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventID = r.Header.Get("Last-Event-ID")
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for _, msg := range []string{`{"id":"1","data":{"body":{}}}`, "malformed", `{"id":"2","data":{"body":{}}}`} {
+			conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer srv.Close()
+
+	s := NewWebSocketSubscription(srv.URL, 0)
+	s.log = nopLogger{}
+
+	errs := make(chan error, 1)
+	go func() { errs <- s.read(context.Background()) }()
+
+	var ids []string
+	for len(ids) < 2 {
+		select {
+		case ev := <-s.Events():
+			ids = append(ids, ev.Id)
+		case err := <-errs:
+			t.Fatalf("read() = %v before sending the events", err)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+	if !equalStrings(ids, []string{"1", "2"}) {
+		t.Errorf("events = %v, want 1 and 2 without the malformed message", ids)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("read() = %v, want nil on a normal close", err)
+	}
+
+	// reconnecting resumes from the last event
+	go func() { errs <- s.read(context.Background()) }()
+	for i := 0; i < 2; i++ {
+		<-s.Events()
+	}
+	<-errs
+	if lastEventID != "2" {
+		t.Errorf("Last-Event-ID = %q, want 2", lastEventID)
+	}
+}
+
+func TestWebSocketSubscriptionHeaders(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer srv.Close()
+
+	s := NewWebSocketSubscription(srv.URL, 0)
+	s.log = nopLogger{}
+	s.header = map[string]string{"Authorization": "Bearer abc"}
+	if err := s.read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer abc" {
+		t.Errorf("Authorization = %q, want Bearer abc", auth)
+	}
+}
@@ -2,4 +2,10 @@ module github.com/roryq/fwd
 
 go 1.16
 
-require github.com/thejerf/suture/v4 v4.0.0
+require (
+	github.com/gorilla/websocket v1.4.2
+	github.com/prometheus/client_golang v1.11.1
+	github.com/thejerf/suture/v4 v4.0.0
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
+	gopkg.in/yaml.v3 v3.0.1
+)
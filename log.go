@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/roryq/fwd/fwd"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type level int
+
+const (
+	levelDebug level = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l level) String() string {
+	return levelNames[l]
+}
+
+// logger writes log lines carrying structured fields such as source, target
+// and event_id. The fields are only written in the json log format; the text
+// format is just the message. A verbose logger writes debug lines whatever
+// the log level, for routes with debug set.
+type logger struct {
+	fields  map[string]interface{}
+	verbose bool
+}
+
+// With returns a logger that adds the field to each line.
+func (l logger) With(key string, value interface{}) fwd.Logger {
+	fields := make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return logger{fields: fields, verbose: l.verbose}
+}
+
+// Verbose returns a logger that writes debug lines too.
+func (l logger) Verbose() fwd.Logger {
+	l.verbose = true
+	return l
+}
+
+func (l logger) Debugf(format string, args ...interface{}) {
+	l.write(levelDebug, format, args...)
+}
+
+func (l logger) Infof(format string, args ...interface{}) {
+	l.write(levelInfo, format, args...)
+}
+
+func (l logger) Warnf(format string, args ...interface{}) {
+	l.write(levelWarn, format, args...)
+}
+
+func (l logger) Errorf(format string, args ...interface{}) {
+	l.write(levelError, format, args...)
+}
+
+func (l logger) write(lvl level, format string, args ...interface{}) {
+	if lvl < logLevel() && !(l.verbose && lvl == levelDebug) {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if logFormat() != "json" {
+		fmt.Println(msg)
+		return
+	}
+
+	line := make(map[string]interface{}, len(l.fields)+3)
+	for k, v := range l.fields {
+		line[k] = v
+	}
+	line["time"] = time.Now().Format(time.RFC3339Nano)
+	line["level"] = lvl.String()
+	line["msg"] = msg
+
+	b, _ := json.Marshal(line)
+	fmt.Println(string(b))
+}
+
+func logFormat() string {
+	if f := os.Getenv("FWD_LOG_FORMAT"); f != "" {
+		return f
+	}
+	return logFormatArg
+}
+
+// logLevel returns the minimum level to log. -debug and FWD_DEBUG are kept as
+// shorthand for the debug level.
+func logLevel() level {
+	if debugMode() {
+		return levelDebug
+	}
+
+	name := logLevelArg
+	if l := os.Getenv("FWD_LOG_LEVEL"); l != "" {
+		name = l
+	}
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return level(i)
+		}
+	}
+	return levelInfo
+}
+
+func debugMode() bool {
+	if e := os.Getenv("FWD_DEBUG"); e != "" {
+		b, _ := strconv.ParseBool(e)
+		return b
+	}
+	return debugArg
+}
+
+func debugf(format string, args ...interface{}) {
+	logger{}.Debugf(format, args...)
+}
+
+func infof(format string, args ...interface{}) {
+	logger{}.Infof(format, args...)
+}
+
+func warnf(format string, args ...interface{}) {
+	logger{}.Warnf(format, args...)
+}
+
+func errorf(format string, args ...interface{}) {
+	logger{}.Errorf(format, args...)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	out, err := ioutil.TempFile("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+	f()
+
+	b, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// setLogFlags sets the log format and level flags until the test ends.
+func setLogFlags(t *testing.T, format, level string) {
+	f, l := logFormatArg, logLevelArg
+	logFormatArg, logLevelArg = format, level
+	t.Cleanup(func() { logFormatArg, logLevelArg = f, l })
+}
+
+func TestLogJSON(t *testing.T) {
+	setLogFlags(t, "json", "info")
+
+	out := captureStdout(t, func() {
+		logger{}.With("source", "https://smee.io/abc").With("event_id", 7).With("status", 502).Warnf("forward failed: %s", "bad gateway")
+	})
+
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &line); err != nil {
+		t.Fatalf("log line %q is not json: %s", out, err)
+	}
+	for k, want := range map[string]interface{}{
+		"level":    "warn",
+		"msg":      "forward failed: bad gateway",
+		"source":   "https://smee.io/abc",
+		"event_id": 7.0,
+		"status":   502.0,
+	} {
+		if line[k] != want {
+			t.Errorf("%s = %v, want %v", k, line[k], want)
+		}
+	}
+	if _, ok := line["time"]; !ok {
+		t.Error("log line has no time")
+	}
+}
+
+func TestLogText(t *testing.T) {
+	setLogFlags(t, "", "info")
+
+	out := captureStdout(t, func() {
+		logger{}.With("source", "https://smee.io/abc").Infof("Forwarded %d", 7)
+	})
+	if out != "Forwarded 7\n" {
+		t.Errorf("text log line = %q, want just the message", out)
+	}
+}
+
+func TestLogFormatEnv(t *testing.T) {
+	setLogFlags(t, "", "info")
+	os.Setenv("FWD_LOG_FORMAT", "json")
+	defer os.Unsetenv("FWD_LOG_FORMAT")
+
+	out := captureStdout(t, func() { infof("hello") })
+	if !strings.HasPrefix(out, "{") {
+		t.Errorf("log line = %q, want json", out)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		level string
+		want  []string
+	}{
+		{"debug", []string{"debug", "info", "warn", "error"}},
+		{"", []string{"info", "warn", "error"}},
+		{"WARN", []string{"warn", "error"}},
+		{"error", []string{"error"}},
+		{"unknown", []string{"info", "warn", "error"}},
+	}
+
+	for _, tt := range tests {
+		setLogFlags(t, "", tt.level)
+		out := captureStdout(t, func() {
+			debugf("debug")
+			infof("info")
+			warnf("warn")
+			errorf("error")
+		})
+		if got := strings.Fields(out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("level %q logged %v, want %v", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestLogDebugMode(t *testing.T) {
+	setLogFlags(t, "", "error")
+	os.Setenv("FWD_DEBUG", "true")
+	defer os.Unsetenv("FWD_DEBUG")
+
+	if out := captureStdout(t, func() { debugf("debug") }); out != "debug\n" {
+		t.Errorf("FWD_DEBUG logged %q, want the debug line", out)
+	}
+}
+
+func TestLogVerbose(t *testing.T) {
+	setLogFlags(t, "", "warn")
+
+	out := captureStdout(t, func() {
+		l := logger{}.Verbose().With("source", "https://smee.io/abc")
+		l.Debugf("debug")
+		l.Infof("info")
+	})
+	if out != "debug\n" {
+		t.Errorf("verbose logger logged %q, want only the debug line", out)
+	}
+}
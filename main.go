@@ -3,13 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/roryq/fwd/fwd"
 	"github.com/thejerf/suture/v4"
-	"io/ioutil"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 )
 
 const (
@@ -17,63 +20,248 @@ const (
 )
 
 var (
-	sourceArg, targetArg, configPathArg string
-	debugArg                            bool
+	sourceArg, targetArg, secretArg           string
+	configPathArgs                            configPaths
+	debugArg, listArg, validateArg, dryRunArg bool
+	versionArg                                bool
+
+	adminAddrArg, metricsAddrArg string
+	enableInjectArg              bool
+	recentEventsArg              int
+
+	routesURLArg       string
+	routesIntervalArg  time.Duration
+	reloadIntervalArg  time.Duration
+	allowRemoteExecArg bool
+
+	accessLogArg, accessLogFormatArg string
+	auditLogArg                      string
+	logFormatArg, logLevelArg        string
+
+	maxEventSizeArg int
+
+	storePathArg string
+	storeSizeArg int
+
+	deadLetterDirArg string
+
+	timeoutArg, dialTimeoutArg, tlsHandshakeTimeoutArg time.Duration
+	idleTimeoutArg, connectTimeoutArg                  time.Duration
+	maxReconnectDelayArg                               time.Duration
+
+	maxInFlightArg  int
+	maxEventsArg    int64
+	drainTimeoutArg time.Duration
+
+	probeTargetsArg, requireTargetsArg bool
+
+	localAddrArg string
 )
 
 func init() {
-	flag.StringVar(&sourceArg, "source", "", "smee.io channel url")
+	flag.StringVar(&sourceArg, "source", "", "smee.io channel url, or a ws(s) url to read events from a websocket")
 	flag.StringVar(&targetArg, "target", "", "forwarding target")
-	flag.StringVar(&configPathArg, "config", defaultConfigPath, "path to config")
+	flag.StringVar(&secretArg, "secret", "", "webhook secret to verify events with in single target mode")
+	flag.Var(&configPathArgs, "config", "`path` to config, a directory of .json and .yaml configs, an http(s) url to fetch it from, or - to read it from stdin (default "+defaultConfigPath+"). May be repeated to merge configs, which must not share sources")
 	flag.BoolVar(&debugArg, "debug", false, "debug logging")
-	flag.Parse()
+	flag.StringVar(&logFormatArg, "log-format", "text", "log format, text or json")
+	flag.StringVar(&logLevelArg, "log-level", "info", "minimum log level: debug, info, warn or error")
+	flag.BoolVar(&versionArg, "version", false, "print the version and exit")
+	flag.BoolVar(&listArg, "list", false, "print the resolved routes and exit")
+	flag.BoolVar(&dryRunArg, "dry-run", false, "log the forwards that would be made without sending them")
+	flag.BoolVar(&validateArg, "validate", false, "check the config without connecting and exit non-zero if it has problems")
+	flag.StringVar(&adminAddrArg, "admin-addr", "", "listen address for the admin server, disabled if empty")
+	flag.IntVar(&recentEventsArg, "recent-events", 100, "how many recent events the admin server shows at /events/recent")
+	flag.StringVar(&metricsAddrArg, "metrics-addr", "", "listen address for the Prometheus metrics server, disabled if empty")
+	flag.StringVar(&routesURLArg, "routes-url", "", "url to periodically fetch routes from, in the config file format")
+	flag.DurationVar(&routesIntervalArg, "routes-interval", time.Minute, "how often to fetch routes from -routes-url")
+	flag.BoolVar(&allowRemoteExecArg, "allow-remote-exec", false, "allow exec: targets and response urls in routes from -routes-url or a -config url. These run commands on this host, so only set it if whoever serves the routes may do so")
+	flag.DurationVar(&reloadIntervalArg, "reload-interval", 2*time.Second, "how often to check the config file for changes, 0 to disable")
+	flag.BoolVar(&enableInjectArg, "enable-inject", false, "allow injecting synthetic events through the admin server")
+	flag.StringVar(&accessLogArg, "access-log", "", "write an access log line per forward to this path, or - for stdout")
+	flag.StringVar(&accessLogFormatArg, "access-log-format", fwd.DefaultAccessLogFormat, "access log format, using $time, $source, $target, $method, $path, $status, $bytes and $duration")
+	flag.StringVar(&auditLogArg, "audit-log", "", "append a JSON line recording the outcome of every event to this path, or - for stdout")
+	flag.IntVar(&maxEventSizeArg, "max-event-size", fwd.DefaultMaxEventSize, "default max size in bytes of an event from a source")
+	flag.StringVar(&storePathArg, "store", "", "path to record received events at, so undelivered ones are replayed on restart, disabled if empty")
+	flag.IntVar(&storeSizeArg, "store-size", fwd.DefaultStoreSize, "number of recent events to keep in the -store")
+	flag.StringVar(&deadLetterDirArg, "dead-letter-dir", "", "default directory to write events that fail every forward attempt to, disabled if empty")
+	flag.DurationVar(&timeoutArg, "timeout", fwd.DefaultTimeout, "default time limit for each forward attempt, including reading the response")
+	flag.DurationVar(&dialTimeoutArg, "dial-timeout", fwd.DefaultDialTimeout, "default time limit for connecting to a target")
+	flag.DurationVar(&tlsHandshakeTimeoutArg, "tls-handshake-timeout", fwd.DefaultTLSHandshakeTimeout, "default time limit for the TLS handshake with a target")
+	flag.DurationVar(&idleTimeoutArg, "idle-timeout", 0, "default time to wait for anything from a source, including keepalives, before reconnecting (0 waits forever)")
+	flag.DurationVar(&connectTimeoutArg, "connect-timeout", fwd.DefaultConnectTimeout, "default time limit for connecting to a source, up to its response headers")
+	flag.DurationVar(&maxReconnectDelayArg, "max-reconnect-delay", fwd.DefaultMaxReconnectDelay, "default cap on the delay before reconnecting to a source that keeps failing")
+	flag.Int64Var(&maxEventsArg, "max-events", 0, "exit once this many events have been forwarded across all routes, 0 for no limit")
+	flag.IntVar(&maxInFlightArg, "max-in-flight", 0, "max forwards in flight at once across all routes, 0 for no limit")
+	flag.DurationVar(&drainTimeoutArg, "drain-timeout", fwd.DefaultDrainTimeout, "time given to forward received events on shutdown, after which they are dead-lettered or logged")
+	flag.BoolVar(&probeTargetsArg, "probe-targets", false, "check that each target can be reached at startup, logging any that can't")
+	flag.BoolVar(&requireTargetsArg, "require-targets", false, "like -probe-targets, but exit if any target can't be reached")
+	flag.StringVar(&localAddrArg, "local-addr", "", "default local IP address to make forwards from, for hosts with more than one interface")
 }
 
 func main() {
-	ctx := context.Background()
-	supervisor := suture.NewSimple("Supervisor")
+	flag.Parse()
+
+	if versionArg {
+		fmt.Println(versionString())
+		return
+	}
+
+	if validateArg {
+		os.Exit(validate())
+	}
+
+	if flag.Arg(0) == "send" {
+		os.Exit(send(flag.Args()[1:]))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// give fwders time to drain before the supervisor gives up on them
+	supervisor := suture.New("Supervisor", suture.Spec{Timeout: drainTimeoutArg + 5*time.Second})
+
+	opts := []fwd.Option{fwd.WithLogger(logger{}), fwd.WithDryRun(dryRun()), fwd.WithDrainTimeout(drainTimeoutArg)}
+
+	if parseMetricsAddr() != "" {
+		opts = append(opts, fwd.WithMetrics(prometheus.DefaultRegisterer))
+	}
+
+	if maxInFlightArg > 0 {
+		opts = append(opts, fwd.WithInFlightLimit(fwd.NewInFlightLimit(maxInFlightArg)))
+	}
+
+	if maxEventsArg > 0 {
+		// events over the limit are left to be replayed from the store
+		limit := fwd.NewEventLimit(maxEventsArg)
+		opts = append(opts, fwd.WithEventLimit(limit))
+		go func() {
+			<-limit.Done()
+			infof("Forwarded %d events, shutting down", maxEventsArg)
+			cancel()
+		}()
+	}
+
+	if accessLogArg != "" {
+		path, err := expandHome(accessLogArg)
+		var l *fwd.AccessLog
+		if err == nil {
+			l, err = fwd.OpenAccessLog(path, accessLogFormatArg)
+		}
+		if err != nil {
+			errorf("error opening access log: %s", err)
+			os.Exit(1)
+		}
+		opts = append(opts, fwd.WithAccessLog(l))
+	}
+
+	if auditLogArg != "" {
+		path, err := expandHome(auditLogArg)
+		var l *fwd.AuditLog
+		if err == nil {
+			l, err = fwd.OpenAuditLog(path)
+		}
+		if err != nil {
+			errorf("error opening audit log: %s", err)
+			os.Exit(1)
+		}
+		opts = append(opts, fwd.WithAuditLog(l))
+	}
+
+	if storePathArg != "" {
+		path, err := expandHome(storePathArg)
+		var store *fwd.EventStore
+		if err == nil {
+			store, err = fwd.OpenEventStore(path, storeSizeArg)
+		}
+		if err != nil {
+			errorf("error opening event store: %s", err)
+			os.Exit(1)
+		}
+		opts = append(opts, fwd.WithStore(store))
+	}
 
-	var c int
+	// only kept for the admin server to show
+	var recent *fwd.RecentEvents
+	if parseAdminAddr() != "" {
+		recent = fwd.NewRecentEvents(recentEventsArg)
+		opts = append(opts, fwd.WithRecentEvents(recent))
+	}
 
+	single := make(map[string]fwd.Route)
 	s, t := parseSource(), parseTarget()
 	if s != "" && t != "" {
-		// single target mode
-		fwd := NewFwder(parseSource(), parseTarget())
-		supervisor.Add(fwd)
-		c += 1
+		single[s] = fwd.Route{Target: t, Secret: parseSecret()}
 	}
 
 	config := parseConfig()
-	for k, v := range config.Routes {
-		supervisor.Add(NewFwder(k, v))
-		c += 1
+
+	if listArg {
+		all := make(map[string]fwd.Route)
+		for _, m := range []map[string]fwd.Route{single, config.Routes} {
+			for k, v := range m {
+				all[fwd.RedactURL(k)] = v.Redacted()
+			}
+		}
+		b, _ := json.MarshalIndent(all, "", "  ")
+		fmt.Println(string(b))
+		return
 	}
 
-	infof("%d routes loaded", c)
-	supervisor.Serve(ctx)
-}
+	routes := newRegistry(supervisor, routeDefaults(), opts...)
+	routes.update(loaderFlags, nil, single)
+	routes.update(loaderConfig, nil, config.Routes)
 
-type configuration struct {
-	Routes map[string]string
-}
+	infof("%d routes loaded", routes.len())
 
-func parseConfig() configuration {
-	bytes, err := ioutil.ReadFile(configPathArg)
-	config := configuration{}
-	if errors.Is(err, os.ErrNotExist) && configPathArg == defaultConfigPath {
-		return config
+	if probeTargetsArg || requireTargetsArg {
+		if !routes.probe(ctx) && requireTargetsArg {
+			errorf("Exiting as not every target can be reached")
+			os.Exit(1)
+		}
 	}
 
-	if err != nil {
-		infof("error reading file: %s", err)
-		return config
+	// only files can be watched, urls and stdin are read once
+	if paths := configPathList(); reloadIntervalArg > 0 && watchable(paths) {
+		supervisor.Add(newConfigWatcher(paths, reloadIntervalArg, routes, config.Routes))
+	}
+
+	if u := parseRoutesURL(); u != "" {
+		supervisor.Add(newRoutesPoller(u, routesIntervalArg, routes))
 	}
 
-	err = json.Unmarshal(bytes, &config)
+	if addr := parseMetricsAddr(); addr != "" {
+		supervisor.Add(&metricsServer{addr: addr})
+	}
+
+	if addr := parseAdminAddr(); addr != "" {
+		supervisor.Add(newAdminServer(addr, routes, recent, enableInject()))
+	}
+
+	supervisor.Serve(ctx)
+	infof("Shut down")
+}
+
+// routeDefaults returns the route settings given by flags, which apply to
+// routes that don't set their own.
+func routeDefaults() fwd.Route {
+	deadLetterDir, err := expandHome(deadLetterDirArg)
 	if err != nil {
-		infof("error parsing file: %s", err)
+		errorf("error finding dead letter dir: %s", err)
+	}
+
+	return fwd.Route{
+		MaxEventSize:        maxEventSizeArg,
+		Timeout:             fwd.Duration(timeoutArg),
+		DialTimeout:         fwd.Duration(dialTimeoutArg),
+		TLSHandshakeTimeout: fwd.Duration(tlsHandshakeTimeoutArg),
+		IdleTimeout:         fwd.Duration(idleTimeoutArg),
+		ConnectTimeout:      fwd.Duration(connectTimeoutArg),
+		MaxReconnectDelay:   fwd.Duration(maxReconnectDelayArg),
+		LocalAddr:           localAddrArg,
+		DeadLetterDir:       deadLetterDir,
 	}
-	return config
 }
 
 func parseTarget() string {
@@ -90,21 +278,46 @@ func parseSource() string {
 	return sourceArg
 }
 
-func debugMode() bool {
-	if e := os.Getenv("FWD_DEBUG"); e != "" {
-		b, _ := strconv.ParseBool(e)
-		return b
+func parseSecret() string {
+	if s := os.Getenv("FWD_SECRET"); s != "" {
+		return s
+	}
+	return secretArg
+}
+
+func parseRoutesURL() string {
+	if u := os.Getenv("FWD_ROUTES_URL"); u != "" {
+		return u
+	}
+	return routesURLArg
+}
+
+func parseAdminAddr() string {
+	if a := os.Getenv("FWD_ADMIN_ADDR"); a != "" {
+		return a
 	}
-	return debugArg
+	return adminAddrArg
 }
 
-func debugf(format string, args ...interface{}) {
-	if debugMode() {
-		fmt.Printf(format+"\n", args...)
+func parseMetricsAddr() string {
+	if a := os.Getenv("FWD_METRICS_ADDR"); a != "" {
+		return a
 	}
+	return metricsAddrArg
 }
 
-func infof(format string, args ...interface{}) {
-	fmt.Printf(format+"\n", args...)
+func enableInject() bool {
+	if e := os.Getenv("FWD_ENABLE_INJECT"); e != "" {
+		b, _ := strconv.ParseBool(e)
+		return b
+	}
+	return enableInjectArg
 }
 
+func dryRun() bool {
+	if d := os.Getenv("FWD_DRY_RUN"); d != "" {
+		b, _ := strconv.ParseBool(d)
+		return b
+	}
+	return dryRunArg
+}
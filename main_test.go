@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMaxEvents runs the test binary again as fwd -max-events 2, reading a
+// source that sends three events and never closes the stream.
+func TestMaxEvents(t *testing.T) {
+	if config := os.Getenv("FWD_TEST_CONFIG"); config != "" {
+		os.Args = []string{"fwd", "-config", config, "-max-events", "2", "-reload-interval", "0"}
+		main()
+		return
+	}
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("id: 1\ndata: {}\n\nid: 2\ndata: {}\n\nid: 3\ndata: {}\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer source.Close()
+	var received int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+	}))
+	defer target.Close()
+
+	dir := writeConfigs(t, map[string]string{
+		"fwd.json": `{"Routes": {"` + source.URL + `": "` + target.URL + `"}}`,
+	})
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMaxEvents$")
+	cmd.Env = append(os.Environ(), "FWD_TEST_CONFIG="+filepath.Join(dir, "fwd.json"))
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("fwd -max-events 2 exited with %v, want 0", err)
+		}
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("fwd -max-events 2 still running after 10s")
+	}
+
+	if n := atomic.LoadInt32(&received); n != 2 {
+		t.Errorf("target received %d events, want 2", n)
+	}
+}
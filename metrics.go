@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// metricsServer serves the Prometheus metrics endpoint.
+type metricsServer struct {
+	addr string
+}
+
+func (m *metricsServer) Serve(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	infof("Metrics server listening on %s", m.addr)
+	return serveHTTP(ctx, m.addr, mux)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/roryq/fwd/fwd"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// routesPoller periodically fetches the route set from a URL and applies any
+// changes to the running routes. If a fetch fails the last known good routes
+// are kept.
+type routesPoller struct {
+	url      string
+	interval time.Duration
+	routes   *registry
+	client   *http.Client
+
+	etag    string
+	current map[string]fwd.Route
+}
+
+func newRoutesPoller(url string, interval time.Duration, routes *registry) *routesPoller {
+	return &routesPoller{
+		url:      url,
+		interval: interval,
+		routes:   routes,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		current:  map[string]fwd.Route{},
+	}
+}
+
+func (p *routesPoller) Serve(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.poll(ctx); err != nil {
+			warnf("error fetching routes from %s, keeping %d current routes: %s", p.url, len(p.current), err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *routesPoller) poll(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.url, nil)
+	if err != nil {
+		return err
+	}
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		debugf("routes at %s not modified", p.url)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	config, err := decodeConfig(req.URL.Path, b)
+	if err != nil {
+		return err
+	}
+	if err := checkRemoteExec(config.Routes); err != nil {
+		return err
+	}
+
+	p.routes.update(loaderRoutesURL, p.current, config.Routes)
+	p.current = config.Routes
+	p.etag = resp.Header.Get("ETag")
+	return nil
+}
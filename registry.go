@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"github.com/roryq/fwd/fwd"
+	"github.com/thejerf/suture/v4"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// The loaders that add routes to the registry. Each source is owned by the
+// loader that added it first, and a route for it from another loader is
+// skipped until the owner removes it.
+const (
+	loaderFlags     = "-source"
+	loaderConfig    = "the config"
+	loaderRoutesURL = "-routes-url"
+)
+
+// registry tracks the fwders running under the supervisor, keyed by source,
+// and the loader each came from. Routes are started with the registry's
+// defaults filling their unset fields, and with its options.
+type registry struct {
+	mu         sync.RWMutex
+	supervisor *suture.Supervisor
+	defaults   fwd.Route
+	opts       []fwd.Option
+	fwders     map[string]*fwd.Fwder
+	tokens     map[string]suture.ServiceToken
+	loaders    map[string]string
+
+	// skipped holds the routes skipped for sources owned by another loader,
+	// by source and loader, to be started once the owner removes the source
+	skipped map[string]map[string]fwd.Route
+}
+
+func newRegistry(supervisor *suture.Supervisor, defaults fwd.Route, opts ...fwd.Option) *registry {
+	return &registry{
+		supervisor: supervisor,
+		defaults:   defaults,
+		opts:       opts,
+		fwders:     make(map[string]*fwd.Fwder),
+		tokens:     make(map[string]suture.ServiceToken),
+		loaders:    make(map[string]string),
+		skipped:    make(map[string]map[string]fwd.Route),
+	}
+}
+
+func (r *registry) add(loader, source string, route fwd.Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addLocked(loader, source, route)
+}
+
+func (r *registry) addLocked(loader, source string, route fwd.Route) {
+	f, err := fwd.NewFwder(source, inherit(route, r.defaults, nil), r.opts...)
+	if err != nil {
+		errorf("Skipping invalid route %s: %s", source, err)
+		return
+	}
+	if !route.IsEnabled() {
+		infof("Skipping disabled route %s", source)
+		return
+	}
+
+	if l, ok := r.loaders[source]; ok && l != loader {
+		errorf("Skipping route %s from %s, which is already loaded from %s", source, loader, l)
+		if r.skipped[source] == nil {
+			r.skipped[source] = make(map[string]fwd.Route)
+		}
+		r.skipped[source][loader] = route
+		return
+	}
+	// replace, rather than run alongside, any route the loader had before
+	r.removeLocked(source)
+
+	infof("Adding route %s", source)
+	r.tokens[source] = r.supervisor.Add(f)
+	r.fwders[source] = f
+	r.loaders[source] = loader
+}
+
+// remove stops the source's route if it was added by the loader, reporting
+// whether it was, and forgets any route for it from the loader that was
+// skipped.
+func (r *registry) remove(loader, source string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.forgetSkipped(loader, source)
+	if r.loaders[source] != loader {
+		return false
+	}
+	r.removeLocked(source)
+	return true
+}
+
+func (r *registry) removeLocked(source string) {
+	if token, ok := r.tokens[source]; ok {
+		infof("Removing route %s", source)
+		if err := r.supervisor.Remove(token); err != nil {
+			errorf("error removing route %s: %s", source, err)
+		}
+	}
+	delete(r.tokens, source)
+	delete(r.fwders, source)
+	delete(r.loaders, source)
+}
+
+func (r *registry) forgetSkipped(loader, source string) {
+	delete(r.skipped[source], loader)
+	if len(r.skipped[source]) == 0 {
+		delete(r.skipped, source)
+	}
+}
+
+// release starts a route skipped for the source, if no loader owns it now,
+// trying the loaders in name order.
+func (r *registry) release(source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	loaders := make([]string, 0, len(r.skipped[source]))
+	for l := range r.skipped[source] {
+		loaders = append(loaders, l)
+	}
+	sort.Strings(loaders)
+
+	for _, l := range loaders {
+		if _, owned := r.loaders[source]; owned {
+			return
+		}
+		route := r.skipped[source][l]
+		r.forgetSkipped(l, source)
+		r.addLocked(l, source, route)
+	}
+}
+
+// update applies the difference between two route sets from the loader,
+// restarting only the routes that were added, removed or changed. Sources the
+// loader no longer runs are released to other loaders.
+func (r *registry) update(loader string, old, new map[string]fwd.Route) {
+	var released []string
+	for source, route := range old {
+		if n, ok := new[source]; !ok || !reflect.DeepEqual(route, n) {
+			if r.remove(loader, source) {
+				released = append(released, source)
+			}
+		}
+	}
+
+	for source, route := range new {
+		if o, ok := old[source]; !ok || !reflect.DeepEqual(route, o) {
+			r.add(loader, source, route)
+		}
+	}
+
+	for _, source := range released {
+		r.release(source)
+	}
+}
+
+func (r *registry) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.fwders)
+}
+
+// routes returns the running routes, keyed by source.
+func (r *registry) routes() map[string]fwd.Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	routes := make(map[string]fwd.Route, len(r.fwders))
+	for source, f := range r.fwders {
+		routes[source] = f.Route()
+	}
+	return routes
+}
+
+// running returns the running fwders, keyed by source.
+func (r *registry) running() map[string]*fwd.Fwder {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fwders := make(map[string]*fwd.Fwder, len(r.fwders))
+	for source, f := range r.fwders {
+		fwders[source] = f
+	}
+	return fwders
+}
+
+// byID returns the fwder for the route with the given id.
+func (r *registry) byID(id string) (*fwd.Fwder, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.fwders {
+		if f.ID() == id {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// probe checks that every route's targets can be reached, logging each
+// result, and reports whether they all can.
+func (r *registry) probe(ctx context.Context) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ok := true
+	for source, f := range r.fwders {
+		errs := f.Probe(ctx)
+		for _, target := range f.Route().AllTargets() {
+			err, probed := errs[target]
+			switch {
+			case !probed:
+			case err != nil:
+				warnf("Target %s of %s is unreachable: %s", fwd.RedactURL(target), source, err)
+				ok = false
+			default:
+				infof("Target %s of %s is reachable", fwd.RedactURL(target), source)
+			}
+		}
+	}
+	return ok
+}
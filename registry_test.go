@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/roryq/fwd/fwd"
+	"testing"
+)
+
+func TestRegistryOwnership(t *testing.T) {
+	routes := newTestRegistry(nil)
+	source := "https://smee.io/abc"
+	config := map[string]fwd.Route{source: {Target: "http://localhost:3000/"}}
+	polled := map[string]fwd.Route{source: {Target: "http://localhost:4000/"}}
+
+	routes.update(loaderConfig, nil, config)
+	routes.update(loaderRoutesURL, nil, polled)
+	if got := routes.routes()[source].Targets; len(got) != 1 || got[0] != "http://localhost:3000/" {
+		t.Fatalf("targets = %v, want the config's route kept", got)
+	}
+
+	// the config changing its route keeps it the owner
+	changed := map[string]fwd.Route{source: {Target: "http://localhost:5000/"}}
+	routes.update(loaderConfig, config, changed)
+	if got := routes.routes()[source].Targets; len(got) != 1 || got[0] != "http://localhost:5000/" {
+		t.Fatalf("targets = %v, want the config's changed route", got)
+	}
+
+	// once the config drops the source, the polled route is started
+	routes.update(loaderConfig, changed, nil)
+	if got := routes.routes()[source].Targets; len(got) != 1 || got[0] != "http://localhost:4000/" {
+		t.Fatalf("targets = %v, want the polled route started", got)
+	}
+
+	// which the config can't take back while the poller has it
+	routes.update(loaderConfig, nil, config)
+	if got := routes.routes()[source].Targets; len(got) != 1 || got[0] != "http://localhost:4000/" {
+		t.Fatalf("targets = %v, want the polled route kept", got)
+	}
+
+	routes.update(loaderRoutesURL, polled, nil)
+	if got := routes.routes()[source].Targets; len(got) != 1 || got[0] != "http://localhost:3000/" {
+		t.Fatalf("targets = %v, want the config's route back", got)
+	}
+
+	routes.update(loaderConfig, config, nil)
+	if n := routes.len(); n != 0 {
+		t.Errorf("%d routes running, want none", n)
+	}
+}
+
+func TestRegistryForgetsDroppedSkippedRoutes(t *testing.T) {
+	routes := newTestRegistry(nil)
+	source := "https://smee.io/abc"
+	config := map[string]fwd.Route{source: {Target: "http://localhost:3000/"}}
+	polled := map[string]fwd.Route{source: {Target: "http://localhost:4000/"}}
+
+	routes.update(loaderConfig, nil, config)
+	routes.update(loaderRoutesURL, nil, polled)
+	routes.update(loaderRoutesURL, polled, nil)
+	routes.update(loaderConfig, config, nil)
+	if n := routes.len(); n != 0 {
+		t.Errorf("%d routes running, want the dropped polled route not started", n)
+	}
+}
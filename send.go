@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/roryq/fwd/fwd"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// headerFlags collects repeated -header "Name: value" flags.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(map[string]string(h))
+}
+
+func (h headerFlags) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return fmt.Errorf("header %q is not in the form Name: value", s)
+	}
+	h[strings.ToLower(strings.TrimSpace(s[:i]))] = strings.TrimSpace(s[i+1:])
+	return nil
+}
+
+// send posts a synthetic event to a target, or to the targets of a route in
+// the config, through the same forwarding path as received events. It prints
+// each response and returns the exit code.
+func send(args []string) int {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	target := fs.String("target", "", "url to send the event to")
+	route := fs.String("route", "", "id or source of a route in the config to send the event through")
+	body := fs.String("body", "{}", "event body, or @path to read it from a file")
+	event := fs.String("event", "push", "event type, sent as X-GitHub-Event")
+	contentType := fs.String("content-type", "", "content type of the body, by default application/json for a JSON body and text/plain otherwise")
+	headers := headerFlags{}
+	fs.Var(headers, "header", "extra header in the form \"Name: value\", can be repeated")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] send [send flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	source, r, err := sendRoute(*route, *target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	b := []byte(*body)
+	if strings.HasPrefix(*body, "@") {
+		if b, err = ioutil.ReadFile(strings.TrimPrefix(*body, "@")); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	headers["content-type"] = *contentType
+	if *contentType == "" {
+		headers["content-type"] = "text/plain"
+		if json.Valid(b) {
+			headers["content-type"] = "application/json"
+		}
+	}
+	headers["x-github-event"] = *event
+	if r.Secret != "" {
+		// sign the body as it will be forwarded, which for JSON is compacted
+		signed := b
+		var buf bytes.Buffer
+		if json.Compact(&buf, b) == nil {
+			signed = buf.Bytes()
+		}
+		headers["x-hub-signature-256"] = fwd.Sign(r.Secret, signed)
+	}
+	data, err := wrapBody(b, headers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	f, err := fwd.NewFwder(source, inherit(r, routeDefaults(), nil), fwd.WithLogger(logger{}), fwd.WithResponseHandler(func(resp fwd.Response) {
+		fmt.Printf("%s %d\n%s\n", resp.Target, resp.Status, resp.Body)
+	}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ev := fwd.SSEvent{
+		Id:   fmt.Sprintf("send-%d", time.Now().UnixNano()),
+		Data: data,
+	}
+	result, err := f.ForwardEvent(ev)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if result.Outcome == fwd.OutcomeSkipped {
+		fmt.Fprintf(os.Stderr, "event skipped: %s\n", result.Reason)
+	}
+	return 0
+}
+
+// sendRoute returns the route to send through: the named route from the config
+// if given, with its targets replaced by the target if that is given too.
+func sendRoute(name, target string) (string, fwd.Route, error) {
+	if name == "" {
+		if target == "" {
+			return "", fwd.Route{}, fmt.Errorf("send needs a -target or -route")
+		}
+		// nothing is subscribed to, but a fwder needs a valid source
+		return "http://localhost/send", fwd.Route{Target: target}, nil
+	}
+
+	config, err := loadConfigs(configPathList())
+	if err != nil {
+		return "", fwd.Route{}, err
+	}
+
+	for source, r := range config.Routes {
+		if source == name || fwd.RouteID(source, r) == name {
+			if target != "" {
+				r.Target, r.Targets, r.TargetsByType = target, nil, nil
+			}
+			return source, r, nil
+		}
+	}
+	return "", fwd.Route{}, fmt.Errorf("no route %q in %s", name, strings.Join(configPathList(), ", "))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/roryq/fwd/fwd"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sendTarget records the last request it receives, responding with status.
+func sendTarget(t *testing.T, status int) (*httptest.Server, *http.Request, *string) {
+	var req http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		req, body = *r, string(b)
+		w.WriteHeader(status)
+		w.Write([]byte("thanks"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &req, &body
+}
+
+func TestSend(t *testing.T) {
+	srv, req, body := sendTarget(t, http.StatusAccepted)
+
+	var code int
+	out := captureStdout(t, func() {
+		code = send([]string{"-target", srv.URL + "/hook", "-body", `{"a": 1}`, "-event", "ping", "-header", "X-Delivery: 123"})
+	})
+	if code != 0 {
+		t.Fatalf("send() = %d, want 0", code)
+	}
+
+	if *body != `{"a":1}` || req.URL.Path != "/hook" {
+		t.Errorf("target received %q at %s", *body, req.URL.Path)
+	}
+	for name, want := range map[string]string{"X-Github-Event": "ping", "X-Delivery": "123", "Content-Type": "application/json"} {
+		if got := req.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if want := srv.URL + "/hook 202\nthanks\n"; !strings.Contains(out, want) {
+		t.Errorf("output = %q, want the response %q", out, want)
+	}
+}
+
+func TestSendRoute(t *testing.T) {
+	srv, req, body := sendTarget(t, http.StatusOK)
+
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fwd.json")
+	config := `{"Routes": {"https://smee.io/abc": {"id": "github", "target": "` + srv.URL + `", "secret": "s3cret"}}}`
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bodyFile := filepath.Join(dir, "body.txt")
+	if err := ioutil.WriteFile(bodyFile, []byte("plain text"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := configPathArgs
+	configPathArgs = configPaths{path}
+	defer func() { configPathArgs = paths }()
+
+	var code int
+	captureStdout(t, func() { code = send([]string{"-route", "github", "-body", "@" + bodyFile}) })
+	if code != 0 {
+		t.Fatalf("send() = %d, want 0", code)
+	}
+	if *body != "plain text" || req.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("target received %q as %s, want the file as text", *body, req.Header.Get("Content-Type"))
+	}
+	if got, want := req.Header.Get("X-Hub-Signature-256"), fwd.Sign("s3cret", []byte(*body)); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+}
+
+func TestSendFails(t *testing.T) {
+	srv, _, _ := sendTarget(t, http.StatusBadRequest)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"target fails", []string{"-target", srv.URL}, 1},
+		{"no target", nil, 2},
+		{"missing body file", []string{"-target", srv.URL, "-body", "@/nonexistent"}, 2},
+	}
+
+	for _, tt := range tests {
+		var code int
+		captureStdout(t, func() { code = send(tt.args) })
+		if code != tt.code {
+			t.Errorf("%s: send() = %d, want %d", tt.name, code, tt.code)
+		}
+	}
+}
+
+func TestHeaderFlags(t *testing.T) {
+	h := headerFlags{}
+	if err := h.Set("X-Api-Key:  abc:def "); err != nil {
+		t.Fatal(err)
+	}
+	if h["x-api-key"] != "abc:def" {
+		t.Errorf("headers = %v, want x-api-key: abc:def", h)
+	}
+	if err := h.Set("no colon"); err == nil {
+		t.Error("Set() of a header without a colon succeeded")
+	}
+}
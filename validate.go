@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"github.com/roryq/fwd/fwd"
+	"sort"
+	"strings"
+)
+
+// validate checks the config and single target flags without connecting to
+// anything, printing the routes that would be loaded and any problems found.
+// It returns the exit code.
+func validate() int {
+	routes := make(map[string]fwd.Route)
+	var problems []error
+
+	if s, t := parseSource(), parseTarget(); s != "" || t != "" {
+		routes[s] = fwd.Route{Target: t}
+	}
+
+	config, err := loadConfigs(configPathList())
+	if err != nil {
+		problems = append(problems, fmt.Errorf("config: %w", err))
+	}
+	for k, v := range config.Routes {
+		routes[k] = v
+	}
+
+	sources := make([]string, 0, len(routes))
+	for s := range routes {
+		sources = append(sources, s)
+	}
+	sort.Strings(sources)
+
+	for _, s := range sources {
+		route := routes[s]
+		problems = append(problems, route.Validate(s)...)
+		disabled := ""
+		if !route.IsEnabled() {
+			disabled = " (disabled)"
+		}
+		targets := route.AllTargets()
+		for i, t := range targets {
+			targets[i] = fwd.RedactURL(t)
+		}
+		fmt.Printf("%s -> %s%s\n", fwd.RedactURL(s), strings.Join(targets, ", "), disabled)
+	}
+	fmt.Printf("%d routes\n", len(routes))
+
+	for _, p := range problems {
+		fmt.Printf("error: %s\n", p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Set at build time with
+//
+//	-ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.date=2021-08-01"
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// versionString describes the build, falling back to the module version for
+// builds made with go install.
+func versionString() string {
+	v := version
+	if v == "" {
+		v = "(devel)"
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+			v = info.Main.Version
+		}
+	}
+
+	s := "fwd " + v
+	if commit != "" {
+		s += fmt.Sprintf(" commit %s", commit)
+	}
+	if date != "" {
+		s += fmt.Sprintf(" built %s", date)
+	}
+	return s
+}
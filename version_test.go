@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+
+	tests := []struct {
+		version, commit, date string
+		want                  string
+	}{
+		{"v1.2.3", "abc1234", "2021-08-01", "fwd v1.2.3 commit abc1234 built 2021-08-01"},
+		{"v1.2.3", "", "", "fwd v1.2.3"},
+		{"", "", "", "fwd (devel)"},
+	}
+
+	for _, tt := range tests {
+		version, commit, date = tt.version, tt.commit, tt.date
+		if got := versionString(); got != tt.want {
+			t.Errorf("versionString() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// TestVersionFlag runs the test binary again as fwd -version.
+func TestVersionFlag(t *testing.T) {
+	if os.Getenv("FWD_TEST_MAIN") != "" {
+		os.Args = []string{"fwd", "-version"}
+		main()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestVersionFlag$")
+	cmd.Env = append(os.Environ(), "FWD_TEST_MAIN=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "fwd ") {
+		t.Errorf("fwd -version printed %q, want the version", out)
+	}
+}
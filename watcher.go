@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/roryq/fwd/fwd"
+	"os"
+	"strings"
+	"time"
+)
+
+// configWatcher polls the config files for changes and applies them to the
+// running routes, leaving unchanged routes connected. Files added to or
+// removed from a config directory count as changes.
+type configWatcher struct {
+	paths    []string
+	interval time.Duration
+	routes   *registry
+
+	current map[string]fwd.Route
+	files   string
+}
+
+func newConfigWatcher(paths []string, interval time.Duration, routes *registry, current map[string]fwd.Route) *configWatcher {
+	w := &configWatcher{
+		paths:    paths,
+		interval: interval,
+		routes:   routes,
+		current:  current,
+	}
+	w.changed()
+	return w
+}
+
+func (w *configWatcher) Serve(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if w.changed() {
+				w.reload()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// changed reports whether any of the files have been modified, added or
+// removed since last checked.
+func (w *configWatcher) changed() bool {
+	files, err := configFiles(w.paths)
+	if err != nil {
+		return false
+	}
+
+	var b strings.Builder
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return false
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", file, fi.ModTime().UnixNano(), fi.Size())
+	}
+
+	if b.String() == w.files {
+		return false
+	}
+	w.files = b.String()
+	return true
+}
+
+func (w *configWatcher) reload() {
+	config, err := loadConfigs(w.paths)
+	if err != nil {
+		warnf("error loading changed config, keeping current routes: %s", err)
+		return
+	}
+
+	infof("Config %s changed, reloading", strings.Join(w.paths, ", "))
+	w.routes.update(loaderConfig, w.current, config.Routes)
+	w.current = config.Routes
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigWatcherReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fwd.json")
+	write := func(config string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"Routes": {"https://smee.io/kept": "http://localhost:3000/", "https://smee.io/removed": "http://localhost:4000/"}}`)
+	config, err := loadConfigs([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := newTestRegistry(config.Routes)
+	w := newConfigWatcher([]string{path}, time.Second, routes, config.Routes)
+	kept := routes.running()["https://smee.io/kept"]
+
+	if w.changed() {
+		t.Fatal("changed() before the config was modified")
+	}
+
+	write(`{"Routes": {"https://smee.io/kept": "http://localhost:3000/", "https://smee.io/added": "http://localhost:5000/"}}`)
+	if !w.changed() {
+		t.Fatal("changed() = false after the config was modified")
+	}
+	w.reload()
+
+	running := routes.running()
+	if len(running) != 2 || running["https://smee.io/added"] == nil {
+		t.Errorf("routes after reload = %v, want kept and added", running)
+	}
+	if running["https://smee.io/kept"] != kept {
+		t.Error("unchanged route was restarted")
+	}
+}
+
+func TestConfigWatcherKeepsRoutesOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fwd.json")
+	if err := ioutil.WriteFile(path, []byte(`{"Routes": {"https://smee.io/a": "http://localhost:3000/"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfigs([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := newTestRegistry(config.Routes)
+	w := newConfigWatcher([]string{path}, time.Second, routes, config.Routes)
+
+	if err := ioutil.WriteFile(path, []byte(`{"Routes": {`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !w.changed() {
+		t.Fatal("changed() = false after the config was modified")
+	}
+	w.reload()
+
+	if routes.len() != 1 {
+		t.Errorf("%d routes running after an invalid config, want 1", routes.len())
+	}
+}
+
+func TestConfigWatcherEnablesRoutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fwd.json")
+	write := func(config string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"Routes": {"https://smee.io/abc": {"target": "http://localhost:3000/", "enabled": false}}}`)
+	config, err := loadConfigs([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := newTestRegistry(config.Routes)
+	w := newConfigWatcher([]string{path}, time.Second, routes, config.Routes)
+	if n := routes.len(); n != 0 {
+		t.Fatalf("%d routes running, want the disabled route not served", n)
+	}
+
+	write(`{"Routes": {"https://smee.io/abc": {"target": "http://localhost:3000/", "enabled": true}}}`)
+	w.reload()
+	if running := routes.running(); running["https://smee.io/abc"] == nil {
+		t.Fatalf("routes after enabling = %v, want the route running", running)
+	}
+
+	write(`{"Routes": {"https://smee.io/abc": {"target": "http://localhost:3000/", "enabled": false}}}`)
+	w.reload()
+	if n := routes.len(); n != 0 {
+		t.Errorf("%d routes running after disabling, want none", n)
+	}
+}